@@ -109,14 +109,18 @@ func Get(ctx context.Context, Client cacheObj.Store, Namespace string, Name stri
 // IsDeletable will check whether the Configuration can be deleted immediately
 // If deletable, it means no external cloud resources are provisioned
 func IsDeletable(ctx context.Context, Client cacheObj.Store, configuration *types.Configuration) (bool, error) {
-	providerRef := GetProviderNamespacedName(configuration)
-	providerObj, err := provider.GetProviderFromConfiguration(ctx, Client, providerRef.Namespace, providerRef.Name)
-	if err != nil {
-		return false, err
+	for _, providerRef := range GetProviderReferences(configuration) {
+		providerObj, err := provider.GetProviderFromConfiguration(ctx, Client, providerRef.Namespace, providerRef.Name)
+		if err != nil {
+			return false, err
+		}
+		// allow Configuration to delete when a Provider doesn't exist or is not ready, which means external cloud
+		// resources are not provisioned at all
+		if providerObj == nil || providerObj.Status.State == types.ProviderIsNotReady {
+			return true, nil
+		}
 	}
-	// allow Configuration to delete when the Provider doesn't exist or is not ready, which means external cloud resources are
-	// not provisioned at all
-	if providerObj == nil || providerObj.Status.State == types.ProviderIsNotReady || configuration.Status.Apply.State == types.TerraformInitError {
+	if configuration.Status.Apply.State == types.TerraformInitError {
 		return true, nil
 	}
 
@@ -172,3 +176,20 @@ func GetProviderNamespacedName(configuration *types.Configuration) *crossplane.R
 		Namespace: provider.DefaultNamespace,
 	}
 }
+
+// GetProviderReferences returns the Providers of the Configuration. A Configuration without providerRefs has the single
+// Provider of GetProviderNamespacedName, without alias.
+func GetProviderReferences(configuration *types.Configuration) []types.ProviderReference {
+	if len(configuration.Spec.ProviderReferences) == 0 {
+		ref := GetProviderNamespacedName(configuration)
+		return []types.ProviderReference{{Name: ref.Name, Namespace: ref.Namespace}}
+	}
+	refs := make([]types.ProviderReference, 0, len(configuration.Spec.ProviderReferences))
+	for _, ref := range configuration.Spec.ProviderReferences {
+		if ref.Namespace == "" {
+			ref.Namespace = provider.DefaultNamespace
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
@@ -16,17 +16,13 @@ import (
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
-	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/hc-install/product"
-	"github.com/hashicorp/hc-install/releases"
-	"github.com/hashicorp/terraform-exec/tfexec"
-	crossplane "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
 	"github.com/pkg/errors"
 	"github.com/ttsubo/client-go/tools/cache"
 	tfcfg "github.com/ttsubo2000/terraform-controller/controllers/configuration"
 	"github.com/ttsubo2000/terraform-controller/controllers/provider"
 	"github.com/ttsubo2000/terraform-controller/controllers/util"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/tools/record"
 	"github.com/ttsubo2000/terraform-controller/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -57,6 +53,10 @@ const (
 	TFVariableSecret = "variable-%s"
 	// TFBackendSecret is the Secret name for Kubernetes backend
 	TFBackendSecret = "tfstate-%s-%s"
+	// providerBlocksFileName is the file of the workspace which configures the aliased provider blocks
+	providerBlocksFileName = "terraform_controller_providers.tf"
+	// planFileName is the file of the workspace with the plan of a run, which apply carries out
+	planFileName = "terraform_controller.tfplan"
 )
 
 // TerraformExecutionType is the type for Terraform execution
@@ -77,10 +77,41 @@ const (
 	ServiceAccountName = "tf-executor-service-account"
 )
 
+// Reasons of the Events of a Configuration
+const (
+	reasonHCLChanged            = "HCLChanged"
+	reasonApplyStarted          = "ApplyStarted"
+	reasonApplySucceeded        = "ApplySucceeded"
+	reasonApplyFailed           = "ApplyFailed"
+	reasonDestroyBlocked        = "DestroyBlocked"
+	reasonResourceLimitExceeded = "ResourceLimitExceeded"
+	reasonTimeout               = "Timeout"
+	reasonCanceled              = "Canceled"
+	reasonOutputsWritten        = "OutputsWritten"
+	reasonGenerateOutputsFailed = "GenerateOutputsFailed"
+)
+
 // ConfigurationReconciler reconciles a Configuration object.
 type ConfigurationReconciler struct {
 	ProviderName string
 	Client       cacheObj.Store
+	Recorder     record.EventRecorder
+	// Executor runs terraform, the LocalExecutor when it is nil
+	Executor Executor
+	// Timeouts are the timeouts of the Configurations which do not set theirs
+	Timeouts Timeouts
+	// KillGracePeriod is how long an interrupted terraform has to persist its state before it is killed
+	KillGracePeriod time.Duration
+
+	runs runRegistry
+}
+
+// executor returns the Executor running terraform for the Configurations
+func (r *ConfigurationReconciler) executor() Executor {
+	if r.Executor == nil {
+		return &LocalExecutor{}
+	}
+	return r.Executor
 }
 
 func (r *ConfigurationReconciler) Reconcile(ctx context.Context, req Request, indexer cache.Indexer) (Result, error) {
@@ -94,6 +125,10 @@ func (r *ConfigurationReconciler) Reconcile(ctx context.Context, req Request, in
 	configuration := obj.(*types.Configuration)
 
 	meta := initTFConfigurationMeta(req, configuration)
+	meta.Timeouts = r.Timeouts.withSpec(configuration.Spec.Timeouts)
+	meta.KillGracePeriod = r.KillGracePeriod
+	meta.run = r.runs.start(req.NamespacedName)
+	defer r.runs.finish(req.NamespacedName, meta.run)
 
 	// add finalizer
 	var isDeleting = !configuration.ObjectMeta.DeletionTimestamp.IsZero()
@@ -129,6 +164,25 @@ func (r *ConfigurationReconciler) Reconcile(ctx context.Context, req Request, in
 			if err.Error() == types.MessageDestroyJobNotCompleted {
 				return Result{RequeueAfter: 3 * time.Second}, nil
 			}
+			reason, state := reasonDestroyBlocked, types.ConfigurationState("")
+			switch {
+			case util.IsResourceLimitExceeded(err):
+				reason, state = reasonResourceLimitExceeded, types.ConfigurationResourceLimitExceeded
+			case isTimeout(err):
+				reason, state = reasonTimeout, types.ConfigurationTimeout
+			case isCanceled(err):
+				reason, state = reasonCanceled, types.ConfigurationCanceled
+			}
+			r.Recorder.Event(configuration, v1.EventTypeWarning, reason, err.Error())
+			if state != "" {
+				if updateErr := meta.updateDestroyStatus(ctx, r.Client, state, err.Error()); updateErr != nil {
+					klog.ErrorS(updateErr, "Failed to update the destroy status", "Namespace", Namespace, "Name", Name)
+				}
+			}
+			// A canceled run is not retried until the Configuration is updated
+			if state == types.ConfigurationCanceled {
+				return Result{}, nil
+			}
 			return Result{RequeueAfter: 3 * time.Second}, errors.Wrap(err, "continue reconciling to destroy cloud resource")
 		}
 		configuration, err := tfcfg.Get(ctx, r.Client, Namespace, Name)
@@ -151,17 +205,63 @@ func (r *ConfigurationReconciler) Reconcile(ctx context.Context, req Request, in
 
 	// Terraform apply (create or update)
 	klog.InfoS("Start: Terraform Apply (cloud resource create/update)", "Namespace", Namespace, "Name", Name)
+	if err := meta.updateApplyStatus(ctx, r.Client, types.ConfigurationProvisioningAndChecking, types.MessageCloudResourceProvisioningAndChecking); err != nil {
+		return Result{}, err
+	}
+	r.Recorder.Event(configuration, v1.EventTypeNormal, reasonApplyStarted, "Started terraform apply")
 	if err := r.terraformApply(ctx, Namespace, configuration, meta); err != nil {
 		if err.Error() == types.MessageApplyJobNotCompleted {
 			return Result{RequeueAfter: 3 * time.Second}, nil
 		}
+		// A run stopped as it exceeded the limits of its resources or its timeout, or as it was canceled, is told
+		// apart from a failed one
+		reason, state := reasonApplyFailed, types.ConfigurationApplyFailed
+		switch {
+		case util.IsResourceLimitExceeded(err):
+			reason, state = reasonResourceLimitExceeded, types.ConfigurationResourceLimitExceeded
+		case isTimeout(err):
+			reason, state = reasonTimeout, types.ConfigurationTimeout
+		case isCanceled(err):
+			reason, state = reasonCanceled, types.ConfigurationCanceled
+		}
+		r.Recorder.Event(configuration, v1.EventTypeWarning, reason, err.Error())
+		if updateErr := meta.updateApplyStatus(ctx, r.Client, state, err.Error()); updateErr != nil {
+			klog.ErrorS(updateErr, "Failed to update the apply status", "Namespace", Namespace, "Name", Name)
+		}
+		// A canceled run is not retried until the Configuration is updated
+		if state == types.ConfigurationCanceled {
+			return Result{}, nil
+		}
 		return Result{RequeueAfter: 3 * time.Second}, errors.Wrap(err, "failed to create/update cloud resource")
 	}
 
 	klog.InfoS("Success: Terraform Apply (cloud resource create/update)", "Namespace", Namespace, "Name", Name)
+	r.recordApplyResult(ctx, Namespace, Name)
 	return Result{}, nil
 }
 
+// recordApplyResult records the Events of a successful apply, according to the status it left
+func (r *ConfigurationReconciler) recordApplyResult(ctx context.Context, namespace, name string) {
+	configuration, err := tfcfg.Get(ctx, r.Client, namespace, name)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get the configuration to record its events", "Namespace", namespace, "Name", name)
+		return
+	}
+	if configuration.Status.Apply.State == types.GeneratingOutputs {
+		r.Recorder.Event(&configuration, v1.EventTypeWarning, reasonGenerateOutputsFailed, configuration.Status.Apply.Message)
+		return
+	}
+	r.Recorder.Event(&configuration, v1.EventTypeNormal, reasonApplySucceeded, types.MessageCloudResourceDeployed)
+	if ref := configuration.Spec.WriteConnectionSecretToReference; ref != nil && ref.Name != "" {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		r.Recorder.Eventf(&configuration, v1.EventTypeNormal, reasonOutputsWritten, "Wrote %d outputs to the Secret %s/%s",
+			len(configuration.Status.Apply.Outputs), namespace, ref.Name)
+	}
+}
+
 // TFConfigurationMeta is all the metadata of a Configuration
 type TFConfigurationMeta struct {
 	Name                  string
@@ -177,11 +277,20 @@ type TFConfigurationMeta struct {
 	ApplyJobName          string
 	DestroyJobName        string
 	Envs                  []v1.EnvVar
-	ProviderReference     *crossplane.Reference
+	ProviderReferences    []types.ProviderReference
 	VariableSecretName    string
 	VariableSecretData    map[string]string
 	DeleteResource        bool
 	Credentials           map[string]string
+	CredentialFiles       []provider.CredentialFile
+	// ProviderBlocks configures the provider blocks of the aliased Providers
+	ProviderBlocks string
+	// Timeouts are the timeouts of the terraform commands
+	Timeouts Timeouts
+	// KillGracePeriod is how long an interrupted terraform has to persist its state before it is killed
+	KillGracePeriod time.Duration
+	// run is the reconcile running terraform, which can be canceled
+	run *terraformRun
 
 	// TerraformImage is the Terraform image which can run `terraform init/plan/apply`
 	TerraformBackendNamespace string
@@ -232,7 +341,7 @@ func initTFConfigurationMeta(req Request, configuration *types.Configuration) *T
 		meta.RemoteGitPath = configuration.Spec.Path
 	}
 
-	meta.ProviderReference = tfcfg.GetProviderNamespacedName(configuration)
+	meta.ProviderReferences = tfcfg.GetProviderReferences(configuration)
 
 	// Check the existence of Terraform state secret which is used to store TF state file. For detailed information,
 	// please refer to https://www.terraform.io/docs/language/settings/backends/kubernetes.html#configuration-variables
@@ -245,18 +354,7 @@ func initTFConfigurationMeta(req Request, configuration *types.Configuration) *T
 func (r *ConfigurationReconciler) terraformApply(ctx context.Context, namespace string, configuration *types.Configuration, meta *TFConfigurationMeta) error {
 	var Client = r.Client
 	klog.InfoS("terraform apply job", "Namespace", namespace, "Name", meta.ApplyJobName)
-
-	for k, v := range meta.VariableSecretData {
-		if v != "" {
-			klog.Infof("Set environment [key: \"%s\", value: \"%s\"]\n", k, v)
-			os.Setenv(k, v)
-			if err := os.Setenv(k, v); err != nil {
-				klog.Fatalf("Cannot set environment variable: %v", err)
-			}
-		}
-	}
-
-	return meta.assembleAndTriggerJob(ctx, Client, TerraformApply)
+	return r.executor().Execute(ctx, Client, meta, TerraformApply)
 }
 
 func (r *ConfigurationReconciler) terraformDestroy(ctx context.Context, NamespacedName string, configuration *types.Configuration, meta *TFConfigurationMeta) error {
@@ -279,7 +377,7 @@ func (r *ConfigurationReconciler) terraformDestroy(ctx context.Context, Namespac
 			configKey := "Configuration" + "/" + configuration.Namespace + "/" + configuration.Name
 			_, _, err := Client.GetByKey(configKey)
 			if err == nil {
-				if err = meta.assembleAndTriggerJob(ctx, Client, TerraformDestroy); err != nil {
+				if err = r.executor().Execute(ctx, Client, meta, TerraformDestroy); err != nil {
 					return err
 				}
 			}
@@ -334,7 +432,9 @@ func (r *ConfigurationReconciler) terraformDestroy(ctx context.Context, Namespac
 				return err
 			}
 		}
-		return nil
+
+		// 5. delete what the terraform runs left
+		return r.executor().Cleanup(ctx, meta)
 	}
 	return errors.New(types.MessageDestroyJobNotCompleted)
 }
@@ -426,6 +526,7 @@ func (r *ConfigurationReconciler) preCheck(ctx context.Context, configuration *t
 
 	if meta.ConfigurationChanged {
 		klog.InfoS("Configuration hanged, reloading...")
+		r.Recorder.Event(configuration, v1.EventTypeNormal, reasonHCLChanged, types.ConfigurationReloadingAsHCLChanged)
 		if err := meta.updateApplyStatus(ctx, storeClient, types.ConfigurationReloading, types.ConfigurationReloadingAsHCLChanged); err != nil {
 			return err
 		}
@@ -433,20 +534,35 @@ func (r *ConfigurationReconciler) preCheck(ctx context.Context, configuration *t
 		return meta.storeTFConfiguration(ctx, storeClient)
 	}
 
-	// Check provider
-	p, err := provider.GetProviderFromConfiguration(ctx, storeClient, meta.ProviderReference.Namespace, meta.ProviderReference.Name)
-	if p == nil {
-		msg := types.ErrProviderNotFound
-		if err != nil {
-			msg = err.Error()
+	// Check providers
+	providers := make([]*types.Provider, 0, len(meta.ProviderReferences))
+	for _, ref := range meta.ProviderReferences {
+		p, err := provider.GetProviderFromConfiguration(ctx, storeClient, ref.Namespace, ref.Name)
+		if p == nil {
+			msg := types.ErrProviderNotFound
+			if err != nil {
+				msg = err.Error()
+			}
+			if updateStatusErr := meta.updateApplyStatus(ctx, storeClient, types.Authorizing, msg); updateStatusErr != nil {
+				return errors.Wrap(updateStatusErr, msg)
+			}
+			return errors.New(msg)
 		}
-		if updateStatusErr := meta.updateApplyStatus(ctx, storeClient, types.Authorizing, msg); updateStatusErr != nil {
-			return errors.Wrap(updateStatusErr, msg)
+		// A Provider whose credentials are not valid would fail the apply, it is retried once the Provider is ready
+		if p.Status.State != types.ProviderIsReady {
+			msg := fmt.Sprintf("%s: %s/%s", types.ErrProviderNotReady, p.Namespace, p.Name)
+			if p.Status.Message != "" {
+				msg += ": " + p.Status.Message
+			}
+			if updateStatusErr := meta.updateApplyStatus(ctx, storeClient, types.ProviderNotReady, msg); updateStatusErr != nil {
+				return errors.Wrap(updateStatusErr, msg)
+			}
+			return errors.New(msg)
 		}
-		return errors.New(msg)
+		providers = append(providers, p)
 	}
 
-	if err := meta.getCredentials(ctx, storeClient, p); err != nil {
+	if err := meta.getCredentials(ctx, storeClient, providers); err != nil {
 		return err
 	}
 
@@ -466,7 +582,7 @@ func (r *ConfigurationReconciler) preCheck(ctx context.Context, configuration *t
 				Namespace: meta.Namespace,
 			},
 			TypeMeta: metav1.TypeMeta{Kind: "Secret"},
-			Data:     meta.VariableSecretData,
+			Data:     toSecretData(meta.VariableSecretData),
 		}
 
 		if err := storeClient.Add(&secret); err != nil {
@@ -475,7 +591,7 @@ func (r *ConfigurationReconciler) preCheck(ctx context.Context, configuration *t
 	case err == nil:
 		variableInSecret = obj.(*types.Secret)
 		for k, v := range meta.VariableSecretData {
-			if val, ok := variableInSecret.Data[k]; !ok || !strings.EqualFold(v, val) {
+			if val, ok := variableInSecret.Data[k]; !ok || !strings.EqualFold(v, string(val)) {
 				meta.EnvChanged = true
 				klog.Info("Job's env changed")
 				if err := meta.updateApplyStatus(ctx, storeClient, types.ConfigurationReloading, types.ConfigurationReloadingAsVariableChanged); err != nil {
@@ -537,86 +653,31 @@ func (meta *TFConfigurationMeta) updateDestroyStatus(ctx context.Context, Client
 	return nil
 }
 
-func (meta *TFConfigurationMeta) assembleAndTriggerJob(ctx context.Context, Client cacheObj.Store, executionType TerraformExecutionType) error {
-
-	key := "ConfigMap/default/tf-Configuration"
-	obj, exists, err := Client.GetByKey(key)
-	if err != nil || !exists {
-		return errors.Wrap(err, "failed to fetch TF configuration ConfigMap")
-	}
-	gotCM := obj.(*types.ConfigMap)
-	for k, v := range gotCM.Data {
-		filename := k
-		content := v
-		f, _ := os.Create(filename)
-		f.Write([]byte(content))
-		f.Close()
-		err := os.Rename(filename, fmt.Sprintf("work/%s", filename))
-		if err != nil {
-			klog.Fatal(err)
-		}
-	}
-
-	installer := &releases.ExactVersion{
-		Product:    product.Terraform,
-		Version:    version.Must(version.NewVersion("1.2.6")),
-		InstallDir: "/tmp",
-	}
-
-	execPath := "/tmp/terraform"
-	if _, err := os.Stat(execPath); err != nil {
-		_, err := installer.Install(ctx)
-		if err != nil {
-			klog.Errorf("error installing Terraform: %s", err)
-		}
-	}
+// storeTFState stores the local state of terraform into the backend Secret
+func (meta *TFConfigurationMeta) storeTFState(Client cacheObj.Store) error {
+	return meta.storeTFStateFile(Client, "/tmp/terraform.tfstate")
+}
 
-	workingDir := "./work"
-	tf, err := tfexec.NewTerraform(workingDir, execPath)
+// storeTFStateFile stores the state file of terraform in path into the backend Secret
+func (meta *TFConfigurationMeta) storeTFStateFile(Client cacheObj.Store, path string) error {
+	tfstate, err := ioutil.ReadFile(path)
 	if err != nil {
-		klog.Errorf("error running NewTerraform: %s", err)
+		return err
 	}
-
-	err = tf.Init(ctx, tfexec.Upgrade(true))
+	payload, err := util.CompressTerraformStateSecret(tfstate)
 	if err != nil {
-		klog.Errorf("error running Init: %s", err)
-	}
-
-	if executionType == "apply" {
-		err = tf.Apply(ctx)
-		if err != nil {
-			return err
-		}
-		// Storing tfstate into Secret resource
-		tfstate, err := ioutil.ReadFile("/tmp/terraform.tfstate")
-		if err != nil {
-			return err
-		}
-		payload, err := util.CompressTerraformStateSecret(tfstate)
-		data := make(map[string]string)
-		data["tfstate"] = string(payload)
-		var secret = &types.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      meta.BackendSecretName,
-				Namespace: meta.TerraformBackendNamespace,
-			},
-			TypeMeta: metav1.TypeMeta{Kind: "Secret"},
-			Data:     data,
-		}
-		err = Client.Add(secret)
-		if err != nil {
-			return err
-		}
-	} else if executionType == "destroy" {
-		err = tf.Destroy(ctx)
-		if err != nil {
-			return err
-		}
-	}
-	if err := meta.updateApplyStatus(ctx, Client, types.Available, types.MessageCloudResourceDeployed); err != nil {
 		return err
 	}
-	return nil
+	data := map[string][]byte{TerraformStateNameInSecret: payload}
+	var secret = &types.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meta.BackendSecretName,
+			Namespace: meta.TerraformBackendNamespace,
+		},
+		TypeMeta: metav1.TypeMeta{Kind: "Secret"},
+		Data:     data,
+	}
+	return Client.Add(secret)
 }
 
 // updateTerraformJob will set deletion finalizer to the Terraform job if its envs are changed, which will result in
@@ -679,7 +740,7 @@ func (meta *TFConfigurationMeta) getTFOutputs(ctx context.Context, Client cacheO
 		return nil, fmt.Errorf("failed to get %s from Terraform State secret %s", TerraformStateNameInSecret, s.Name)
 	}
 
-	tfStateJSON, err := util.DecompressTerraformStateSecret(string(tfStateData))
+	tfStateJSON, err := util.DecompressTerraformStateSecret(tfStateData)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to decompress state secret data")
 	}
@@ -706,9 +767,9 @@ func (meta *TFConfigurationMeta) getTFOutputs(ctx context.Context, Client cacheO
 	if ns == "" {
 		ns = "default"
 	}
-	data := make(map[string]string)
+	data := make(map[string][]byte)
 	for k, v := range outputs {
-		data[k] = v.Value
+		data[k] = []byte(v.Value)
 	}
 	var gotSecret *types.Secret
 	configurationName := configuration.ObjectMeta.Name
@@ -765,7 +826,7 @@ func (meta *TFConfigurationMeta) prepareTFVariables(configuration *types.Configu
 	if configuration == nil {
 		return errors.New("configuration is nil")
 	}
-	if meta.ProviderReference == nil {
+	if len(meta.ProviderReferences) == 0 {
 		return errors.New("The referenced provider could not be retrieved")
 	}
 
@@ -793,15 +854,20 @@ func (meta *TFConfigurationMeta) prepareTFVariables(configuration *types.Configu
 		valueFrom.SecretKeyRef.Name = meta.VariableSecretName
 		envs = append(envs, v1.EnvVar{Name: k, ValueFrom: valueFrom})
 	}
-	// make sure the env of the Job is set
-	if envs == nil {
-		return errors.New(provider.ErrCredentialNotRetrieved)
-	}
 	meta.Envs = envs
 	meta.VariableSecretData = data
 	return nil
 }
 
+// toSecretData converts string values into the data of a Secret
+func toSecretData(values map[string]string) map[string][]byte {
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		data[k] = []byte(v)
+	}
+	return data
+}
+
 func getTerraformJSONVariable(tfVariables *runtime.RawExtension) (map[string]interface{}, error) {
 	variables, err := tfcfg.RawExtension2Map(tfVariables)
 	if err != nil {
@@ -917,19 +983,44 @@ func (meta *TFConfigurationMeta) CheckWhetherConfigurationChanges(ctx context.Co
 	}
 }
 
-// getCredentials will get credentials from secret of the Provider
-func (meta *TFConfigurationMeta) getCredentials(ctx context.Context, Client cacheObj.Store, providerObj *types.Provider) error {
-	region, err := tfcfg.SetRegion(ctx, Client, meta.Namespace, meta.Name, providerObj)
-	if err != nil {
-		return err
-	}
-	credentials, err := provider.GetProviderCredentials(ctx, Client, providerObj, region)
-	if err != nil {
-		return err
-	}
-	if credentials == nil {
-		return errors.New(provider.ErrCredentialNotRetrieved)
+// getCredentials merges the credentials of the providers, which are in the order of meta.ProviderReferences. The
+// Provider without alias sets the env of the default provider block, the aliased ones configure their own provider
+// block with TF_VAR_tfc_<alias>_* variables. The merged env is only passed to the terraform of the run.
+func (meta *TFConfigurationMeta) getCredentials(ctx context.Context, Client cacheObj.Store, providers []*types.Provider) error {
+	merged := &provider.Credentials{Env: map[string]string{}}
+	for i, ref := range meta.ProviderReferences {
+		providerObj := providers[i]
+		var (
+			credentials *provider.Credentials
+			err         error
+		)
+		if ref.Alias == "" {
+			region := ref.Region
+			if region == "" {
+				if region, err = tfcfg.SetRegion(ctx, Client, meta.Namespace, meta.Name, providerObj); err != nil {
+					return err
+				}
+			}
+			credentials, err = provider.GetProviderCredentials(ctx, Client, providerObj, region)
+		} else {
+			region := ref.Region
+			if region == "" {
+				region = providerObj.Spec.Region
+			}
+			credentials, err = provider.GetAliasedProviderCredentials(ctx, Client, providerObj, ref.Alias, region)
+		}
+		if err != nil {
+			return err
+		}
+		if credentials == nil {
+			return errors.New(provider.ErrCredentialNotRetrieved)
+		}
+		if err := merged.Merge(credentials); err != nil {
+			return errors.Wrapf(err, "failed to merge the credentials of the Provider %s", providerObj.Name)
+		}
 	}
-	meta.Credentials = credentials
+	meta.Credentials = merged.Env
+	meta.CredentialFiles = merged.Files
+	meta.ProviderBlocks = merged.HCL
 	return nil
 }
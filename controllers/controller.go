@@ -3,12 +3,14 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/klog/v2"
 
 	"github.com/ttsubo/client-go/tools/cache"
 	"github.com/ttsubo/client-go/util/workqueue"
+	"github.com/ttsubo2000/terraform-controller/metrics"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -80,6 +82,10 @@ type Controller struct {
 	indexer  cache.Indexer
 	Queue    workqueue.RateLimitingInterface
 	informer cache.Controller
+
+	// informerRunning and workerRunning are set while the informer and the worker run, see Ready
+	informerRunning int32
+	workerRunning   int32
 }
 
 func newController(name string, r Reconciler, queue workqueue.RateLimitingInterface, indexer cache.Indexer, informer cache.Controller) *Controller {
@@ -92,25 +98,52 @@ func newController(name string, r Reconciler, queue workqueue.RateLimitingInterf
 	}
 }
 
-// Run controller.
-func (c *Controller) Run(ctx context.Context, errChan chan error) {
+// Start runs the informer and the worker of the controller until ctx is done. The worker then stops taking items
+// from the queue, and Start returns once the reconcile in progress completed.
+func (c *Controller) Start(ctx context.Context) error {
 	klog.Infof("Starting  %s controller", c.Name)
-	go c.informer.Run(ctx.Done())
-	go c.runWorker(ctx, errChan)
+	go func() {
+		atomic.StoreInt32(&c.informerRunning, 1)
+		defer atomic.StoreInt32(&c.informerRunning, 0)
+		c.informer.Run(ctx.Done())
+	}()
+
+	errCh := make(chan error, 1)
+	go c.runWorker(ctx, errCh)
+
+	select {
+	case <-ctx.Done():
+		klog.Infof("Shutdown signal received on %s controller", c.Name)
+	case err := <-errCh:
+		return err
+	}
+	c.Queue.ShutDown()
+	<-errCh
+	klog.Infof("Stopped %s controller", c.Name)
+	return nil
 }
 
 func (c *Controller) runWorker(ctx context.Context, errCh chan error) {
-	childCtx, childCancel := context.WithCancel(ctx)
-	defer childCancel()
-
-	go func() {
-		for c.processNextWorkItem(childCtx) {
-		}
-		errCh <- fmt.Errorf("Error: %s", "WorkerQueue Error")
-	}()
+	atomic.StoreInt32(&c.workerRunning, 1)
+	for c.processNextWorkItem(ctx) {
+	}
+	atomic.StoreInt32(&c.workerRunning, 0)
+	if ctx.Err() != nil {
+		errCh <- nil
+		return
+	}
+	errCh <- fmt.Errorf("Error: %s", "WorkerQueue Error")
+}
 
-	<-childCtx.Done()
-	klog.Infof("Shutdown signal received in runWorker on %s controller", c.Name)
+// Ready returns an error until the informer and the worker of the controller run, and once one of them stopped
+func (c *Controller) Ready() error {
+	if atomic.LoadInt32(&c.informerRunning) == 0 {
+		return fmt.Errorf("the informer of the %s controller is not running", c.Name)
+	}
+	if atomic.LoadInt32(&c.workerRunning) == 0 {
+		return fmt.Errorf("the worker of the %s controller is not running", c.Name)
+	}
+	return nil
 }
 
 func (c *Controller) processNextWorkItem(ctx context.Context) bool {
@@ -121,8 +154,12 @@ func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	}
 
 	defer c.Queue.Done(obj)
+	if ctx.Err() != nil {
+		// The controller is stopping, the items left in the queue are not reconciled
+		return false
+	}
 
-	c.reconcileHandler(ctx, obj)
+	c.reconcileHandler(reconcileContext(ctx), obj)
 	return true
 }
 
@@ -136,11 +173,15 @@ func (c *Controller) reconcileHandler(ctx context.Context, obj interface{}) {
 		// Return true, don't take a break
 		return
 	}
+	reconcileStart := time.Now()
 	result, err := c.Do.Reconcile(ctx, req, c.indexer)
+	metrics.ReconcileTime.WithLabelValues(c.Name).Observe(time.Since(reconcileStart).Seconds())
 
 	switch {
 	case err != nil:
 		c.Queue.AddRateLimited(req)
+		metrics.ReconcileErrors.WithLabelValues(c.Name).Inc()
+		metrics.ReconcileTotal.WithLabelValues(c.Name, metrics.ResultError).Inc()
 		klog.Error(err, "Reconciler error")
 	case result.RequeueAfter > 0:
 		// The result.RequeueAfter request will be lost, if it is returned
@@ -149,16 +190,19 @@ func (c *Controller) reconcileHandler(ctx context.Context, obj interface{}) {
 		// to result.RequestAfter
 		c.Queue.Forget(obj)
 		c.Queue.AddAfter(req, result.RequeueAfter)
+		metrics.ReconcileTotal.WithLabelValues(c.Name, metrics.ResultRequeueAfter).Inc()
 	case result.Requeue:
 		c.Queue.AddRateLimited(req)
+		metrics.ReconcileTotal.WithLabelValues(c.Name, metrics.ResultRequeue).Inc()
 	default:
 		c.Queue.Forget(obj)
+		metrics.ReconcileTotal.WithLabelValues(c.Name, metrics.ResultSuccess).Inc()
 	}
 }
 
 // NewController creates a new Controller.
 func NewController(name string, r Reconciler, objType runtime.Object, clientState cacheObj.Store) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name)
 
 	indexer, informer := cache.NewIndexerInformer(&cache.ListWatch{}, objType, 0, cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
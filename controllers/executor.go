@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/pkg/errors"
+	"github.com/ttsubo2000/terraform-controller/controllers/provider"
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	"k8s.io/klog/v2"
+)
+
+// Executor runs terraform apply and destroy for the Configurations
+type Executor interface {
+	// Execute runs terraform for meta. It returns the error MessageApplyJobNotCompleted or
+	// MessageDestroyJobNotCompleted while the run goes on, to be called again until the run is over.
+	Execute(ctx context.Context, Client cacheObj.Store, meta *TFConfigurationMeta, executionType TerraformExecutionType) error
+	// Cleanup removes what the runs of meta left, once its cloud resources are destroyed
+	Cleanup(ctx context.Context, meta *TFConfigurationMeta) error
+}
+
+// LocalExecutor runs terraform as a child process of the controller, until the run is over
+type LocalExecutor struct{}
+
+var _ Executor = &LocalExecutor{}
+
+// Execute runs terraform in the workspace ./work, with the state in the local backend of the Configuration
+func (e *LocalExecutor) Execute(ctx context.Context, Client cacheObj.Store, meta *TFConfigurationMeta, executionType TerraformExecutionType) error {
+	data, err := meta.getTFConfigurationData(Client)
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		filename := k
+		content := v
+		f, _ := os.Create(filename)
+		f.Write([]byte(content))
+		f.Close()
+		err := os.Rename(filename, fmt.Sprintf("work/%s", filename))
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	// The workspace is shared by the Configurations, so the provider blocks of a former run are removed
+	providerBlocksFile := filepath.Join("work", providerBlocksFileName)
+	if meta.ProviderBlocks != "" {
+		if err := ioutil.WriteFile(providerBlocksFile, []byte(meta.ProviderBlocks), 0644); err != nil {
+			return errors.Wrap(err, "failed to write the aliased provider blocks")
+		}
+	} else if err := os.Remove(providerBlocksFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove the aliased provider blocks")
+	}
+
+	installer := &releases.ExactVersion{
+		Product:    product.Terraform,
+		Version:    version.Must(version.NewVersion("1.2.6")),
+		InstallDir: "/tmp",
+	}
+
+	execPath := "/tmp/terraform"
+	if _, err := os.Stat(execPath); err != nil {
+		_, err := installer.Install(ctx)
+		if err != nil {
+			klog.Errorf("error installing Terraform: %s", err)
+		}
+	}
+
+	// The processes of terraform are confined to the resources set by the RESOURCES_* env variables
+	var limitedRun *util.LimitedRun
+	exceeded := func(err error) error { return err }
+	if limits := meta.resourceLimits(); !limits.IsZero() {
+		if limitedRun, err = util.StartLimitedRun(meta.Namespace+"-"+meta.Name, limits); err != nil {
+			return err
+		}
+		defer limitedRun.Close()
+		exceeded = limitedRun.Exceeded
+	}
+
+	// Credential files are written outside of the workspace, and only live for the time of the run
+	credentialFilesEnv, removeCredentialFiles, err := provider.WriteCredentialFiles(meta.Name, meta.CredentialFiles)
+	if err != nil {
+		return err
+	}
+	defer removeCredentialFiles()
+
+	// The env of the run is only set on its terraform, so it never reaches the runs of other Configurations
+	runName := meta.Namespace + "/" + meta.Name
+	tfPath, tfEnv, err := util.WrapTerraform(runName, execPath, meta.runEnv(credentialFilesEnv), limitedRun)
+	if err != nil {
+		return err
+	}
+	workingDir := "./work"
+	tf, err := tfexec.NewTerraform(workingDir, tfPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to run terraform")
+	}
+	if err := tf.SetEnv(tfexec.CleanEnv(tfEnv)); err != nil {
+		return errors.Wrap(err, "failed to set the env of terraform")
+	}
+
+	// Only the processes of this run are signaled when one of its commands is stopped
+	signal := func(command string, sig syscall.Signal) error {
+		_, err := util.SignalRun(runName, sig)
+		return err
+	}
+	// Terraform is interrupted rather than killed when the controller stops, and is not started anymore once it stops
+	stopInterrupt := interruptOnShutdown(ctx)
+	defer stopInterrupt()
+	if err := checkShutdown(ctx); err != nil {
+		return err
+	}
+
+	err = meta.runCommand(ctx, "init", meta.Timeouts.Init, signal, func(ctx context.Context) error {
+		return exceeded(tf.Init(ctx, tfexec.Upgrade(true)))
+	})
+	if err != nil {
+		return err
+	}
+
+	if executionType == "apply" {
+		if err := checkShutdown(ctx); err != nil {
+			return err
+		}
+		// The plan is saved, so that apply only changes what was planned within the timeout of plan
+		defer os.Remove(filepath.Join(workingDir, planFileName))
+		err = meta.runCommand(ctx, "plan", meta.Timeouts.Plan, signal, func(ctx context.Context) error {
+			_, err := tf.Plan(ctx, tfexec.Out(planFileName))
+			return exceeded(err)
+		})
+		if err != nil {
+			return err
+		}
+		if err := checkShutdown(ctx); err != nil {
+			return err
+		}
+		err = meta.runCommand(ctx, "apply", meta.Timeouts.Apply, signal, func(ctx context.Context) error {
+			return exceeded(tf.Apply(ctx, tfexec.DirOrPlan(planFileName)))
+		})
+		// The state of an interrupted or failed apply is stored as well, as resources may have been created
+		if storeErr := meta.storeTFState(Client); storeErr != nil {
+			if err != nil {
+				klog.ErrorS(storeErr, "Failed to store the state of the failed apply", "Name", meta.Name)
+				return err
+			}
+			return storeErr
+		}
+		if err != nil {
+			return err
+		}
+	} else if executionType == "destroy" {
+		if err := checkShutdown(ctx); err != nil {
+			return err
+		}
+		err = meta.runCommand(ctx, "destroy", meta.Timeouts.Destroy, signal, func(ctx context.Context) error {
+			return exceeded(tf.Destroy(ctx))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := meta.updateApplyStatus(ctx, Client, types.Available, types.MessageCloudResourceDeployed); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Cleanup does nothing, as the runs only leave the shared workspace
+func (e *LocalExecutor) Cleanup(ctx context.Context, meta *TFConfigurationMeta) error {
+	return nil
+}
+
+// runEnv returns the env of the terraform of the run: the variables, the credentials of the Providers, and the paths
+// of the credential files
+func (meta *TFConfigurationMeta) runEnv(credentialFilesEnv map[string]string) map[string]string {
+	env := make(map[string]string, len(meta.VariableSecretData)+len(credentialFilesEnv))
+	for k, v := range meta.VariableSecretData {
+		env[k] = v
+	}
+	for k, v := range credentialFilesEnv {
+		env[k] = v
+	}
+	return env
+}
+
+// resourceLimits returns the resources of terraform set by the RESOURCES_* env variables
+func (meta *TFConfigurationMeta) resourceLimits() util.ResourceLimits {
+	var limits util.ResourceLimits
+	if meta.ResourcesLimitsCPU != "" {
+		limits.CPULimitMilli = meta.ResourcesLimitsCPUQuantity.MilliValue()
+	}
+	if meta.ResourcesLimitsMemory != "" {
+		limits.MemoryLimitBytes = meta.ResourcesLimitsMemoryQuantity.Value()
+	}
+	if meta.ResourcesRequestsCPU != "" {
+		limits.CPURequestMilli = meta.ResourcesRequestsCPUQuantity.MilliValue()
+	}
+	if meta.ResourcesRequestsMemory != "" {
+		limits.MemoryRequestBytes = meta.ResourcesRequestsMemoryQuantity.Value()
+	}
+	return limits
+}
+
+// getTFConfigurationData returns the files of the input configuration stored for the runs
+func (meta *TFConfigurationMeta) getTFConfigurationData(Client cacheObj.Store) (map[string]string, error) {
+	key := "ConfigMap/default/tf-Configuration"
+	obj, exists, err := Client.GetByKey(key)
+	if err != nil || !exists {
+		return nil, errors.Wrap(err, "failed to fetch TF configuration ConfigMap")
+	}
+	return obj.(*types.ConfigMap).Data, nil
+}
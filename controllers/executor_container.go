@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/ttsubo2000/terraform-controller/controllers/provider"
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultContainerRuntime is the CLI of the container runtime running terraform
+	DefaultContainerRuntime = "docker"
+	// DefaultContainerWorkspaceDir is the directory of the workspaces of the Configurations run in containers
+	DefaultContainerWorkspaceDir = "work/containers"
+
+	// containerWorkspace is the mount path of the workspace of a Configuration in its containers
+	containerWorkspace = "/workspace"
+	// containerStateFileName is the state file of the local backend, in the workspace
+	containerStateFileName = "terraform.tfstate"
+	// credentialsDirName is the directory of the workspace with the credential files of a run
+	credentialsDirName = ".credentials"
+	// maxContainerOutput is the length of the output of a failed container kept in its error
+	maxContainerOutput = 4096
+)
+
+// containerBackendOverride is the backend of the containers, keeping the state in the workspace
+var containerBackendOverride = `terraform {
+  backend "local" {
+    path = %q
+  }
+}
+`
+
+// ContainerExecutor runs each terraform command of a Configuration in a container of a local container runtime,
+// like docker or podman. A container only mounts the workspace of its Configuration, and gets the variables and
+// credentials from an env file.
+type ContainerExecutor struct {
+	// Runtime is the CLI of the container runtime
+	Runtime string
+	// Image is the image of terraform, whose entrypoint is terraform
+	Image string
+	// Network is the network of the containers, the default of the runtime when it is empty
+	Network string
+	// WorkspaceDir is the directory of the workspaces, one per Configuration
+	WorkspaceDir string
+}
+
+var _ Executor = &ContainerExecutor{}
+
+// NewContainerExecutor returns the ContainerExecutor with the default runtime, image and workspace directory
+func NewContainerExecutor() *ContainerExecutor {
+	return &ContainerExecutor{
+		Runtime:      DefaultContainerRuntime,
+		Image:        DefaultTerraformImage,
+		WorkspaceDir: DefaultContainerWorkspaceDir,
+	}
+}
+
+// Execute runs terraform init, then plan and apply or destroy, in the containers of meta, until the run is over
+func (e *ContainerExecutor) Execute(ctx context.Context, Client cacheObj.Store, meta *TFConfigurationMeta, executionType TerraformExecutionType) error {
+	workspace, err := e.prepareWorkspace(Client, meta)
+	if err != nil {
+		return err
+	}
+	envFile, removeEnvFile, err := e.writeEnvFile(workspace, meta)
+	if err != nil {
+		return err
+	}
+	defer removeEnvFile()
+
+	// Only the container of the stopped command is signaled
+	signal := func(command string, sig syscall.Signal) error {
+		// run kills the container once its context is canceled
+		if sig == syscall.SIGKILL {
+			return nil
+		}
+		name := containerName(meta, command)
+		if out, err := exec.Command(e.Runtime, "kill", "--signal", strconv.Itoa(int(sig)), name).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "failed to signal the container %s: %s", name, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	// The runtime CLI forwards the interrupt to terraform in the container
+	stopInterrupt := interruptOnShutdown(ctx)
+	defer stopInterrupt()
+	if err := checkShutdown(ctx); err != nil {
+		return err
+	}
+	err = meta.runCommand(ctx, "init", meta.Timeouts.Init, signal, func(ctx context.Context) error {
+		return e.run(ctx, meta, workspace, envFile, "init", "-upgrade", "-input=false", "-no-color")
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkShutdown(ctx); err != nil {
+		return err
+	}
+	if executionType == TerraformDestroy {
+		err = meta.runCommand(ctx, "destroy", meta.Timeouts.Destroy, signal, func(ctx context.Context) error {
+			return e.run(ctx, meta, workspace, envFile, "destroy", "-auto-approve", "-input=false", "-no-color")
+		})
+	} else {
+		// The plan is saved, so that apply only changes what was planned within the timeout of plan
+		defer os.Remove(filepath.Join(workspace, planFileName))
+		err = meta.runCommand(ctx, "plan", meta.Timeouts.Plan, signal, func(ctx context.Context) error {
+			return e.run(ctx, meta, workspace, envFile, "plan", "-out="+planFileName, "-input=false", "-no-color")
+		})
+		if err != nil {
+			return err
+		}
+		if err := checkShutdown(ctx); err != nil {
+			return err
+		}
+		err = meta.runCommand(ctx, "apply", meta.Timeouts.Apply, signal, func(ctx context.Context) error {
+			return e.run(ctx, meta, workspace, envFile, "apply", "-auto-approve", "-input=false", "-no-color", planFileName)
+		})
+	}
+	if executionType == TerraformApply {
+		// The state of an interrupted or failed apply is stored as well, as resources may have been created
+		if storeErr := meta.storeTFStateFile(Client, filepath.Join(workspace, containerStateFileName)); storeErr != nil {
+			if err != nil {
+				klog.ErrorS(storeErr, "Failed to store the state of the failed apply", "Name", meta.Name)
+				return err
+			}
+			return storeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return meta.updateApplyStatus(ctx, Client, types.Available, types.MessageCloudResourceDeployed)
+}
+
+// Cleanup removes the workspace of meta
+func (e *ContainerExecutor) Cleanup(ctx context.Context, meta *TFConfigurationMeta) error {
+	if err := os.RemoveAll(e.workspace(meta)); err != nil {
+		return errors.Wrap(err, "failed to remove the workspace of the terraform containers")
+	}
+	return nil
+}
+
+// workspace returns the workspace directory of meta
+func (e *ContainerExecutor) workspace(meta *TFConfigurationMeta) string {
+	return filepath.Join(e.WorkspaceDir, meta.Namespace, meta.Name)
+}
+
+// prepareWorkspace writes the configuration of meta into its workspace, with the state of the backend Secret when
+// the workspace has none, like after its workspace was lost. It returns the absolute path of the workspace.
+func (e *ContainerExecutor) prepareWorkspace(Client cacheObj.Store, meta *TFConfigurationMeta) (string, error) {
+	workspace, err := filepath.Abs(e.workspace(meta))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve the workspace of the terraform containers")
+	}
+	if err := os.MkdirAll(workspace, 0700); err != nil {
+		return "", errors.Wrap(err, "failed to create the workspace of the terraform containers")
+	}
+	data, err := meta.getTFConfigurationData(Client)
+	if err != nil {
+		return "", err
+	}
+	files := make(map[string]string, len(data)+1)
+	for k, v := range data {
+		files[k] = v
+	}
+	files[backendOverrideFileName] = fmt.Sprintf(containerBackendOverride, containerStateFileName)
+	for name, content := range files {
+		if name != filepath.Base(name) {
+			return "", errors.Errorf("the configuration file name %q is not a plain file name", name)
+		}
+		if err := ioutil.WriteFile(filepath.Join(workspace, name), []byte(content), 0600); err != nil {
+			return "", errors.Wrapf(err, "failed to write the configuration file %s", name)
+		}
+	}
+	providerBlocksFile := filepath.Join(workspace, providerBlocksFileName)
+	if meta.ProviderBlocks != "" {
+		if err := ioutil.WriteFile(providerBlocksFile, []byte(meta.ProviderBlocks), 0600); err != nil {
+			return "", errors.Wrap(err, "failed to write the aliased provider blocks")
+		}
+	} else if err := os.Remove(providerBlocksFile); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "failed to remove the aliased provider blocks")
+	}
+
+	stateFile := filepath.Join(workspace, containerStateFileName)
+	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
+		key := "Secret" + "/" + meta.TerraformBackendNamespace + "/" + meta.BackendSecretName
+		if obj, exists, err := Client.GetByKey(key); err == nil && exists {
+			tfstate, err := util.DecompressTerraformStateSecret(obj.(*types.Secret).Data[TerraformStateNameInSecret])
+			if err != nil {
+				return "", errors.Wrap(err, "failed to decompress the terraform state")
+			}
+			if err := ioutil.WriteFile(stateFile, tfstate, 0600); err != nil {
+				return "", errors.Wrap(err, "failed to restore the terraform state")
+			}
+			klog.InfoS("Restored the terraform state into the workspace", "Namespace", meta.Namespace, "Name", meta.Name)
+		}
+	}
+	return workspace, nil
+}
+
+// writeEnvFile writes the env file of the containers of meta, outside of the workspace, and the credential files
+// into the workspace, as the containers only mount the workspace. It returns the env file, and a function removing
+// the files.
+func (e *ContainerExecutor) writeEnvFile(workspace string, meta *TFConfigurationMeta) (string, func(), error) {
+	credentialsDir := filepath.Join(workspace, credentialsDirName)
+	if err := os.RemoveAll(credentialsDir); err != nil {
+		return "", nil, errors.Wrap(err, "failed to remove the credential files of a former run")
+	}
+	containerFilesEnv := map[string]string{}
+	if len(meta.CredentialFiles) != 0 {
+		if err := os.Mkdir(credentialsDir, 0700); err != nil {
+			return "", nil, errors.Wrap(err, "failed to create the directory of the credential files")
+		}
+		credentialFilesEnv, err := provider.WriteCredentialFilesIn(credentialsDir, meta.CredentialFiles)
+		if err != nil {
+			os.RemoveAll(credentialsDir)
+			return "", nil, err
+		}
+		for k, v := range credentialFilesEnv {
+			containerFilesEnv[k] = path.Join(containerWorkspace, credentialsDirName, filepath.Base(v))
+		}
+	}
+	env := meta.runEnv(containerFilesEnv)
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var content bytes.Buffer
+	for _, k := range keys {
+		if strings.ContainsAny(env[k], "\r\n") {
+			os.RemoveAll(credentialsDir)
+			return "", nil, errors.Errorf("the variable %s has a line break, which can not be passed to a container in an env file", k)
+		}
+		fmt.Fprintf(&content, "%s=%s\n", k, env[k])
+	}
+
+	// TempFile creates the file with the permissions 0600
+	f, err := ioutil.TempFile("", "tf-env-"+meta.Name+"-")
+	if err == nil {
+		_, err = f.Write(content.Bytes())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	cleanup := func() {
+		if f != nil {
+			os.Remove(f.Name())
+		}
+		if err := os.RemoveAll(credentialsDir); err != nil {
+			klog.ErrorS(err, "Failed to remove the credential files", "Directory", credentialsDir)
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, errors.Wrap(err, "failed to write the env file of the terraform containers")
+	}
+	return f.Name(), cleanup, nil
+}
+
+// containerName returns the name of the container of the terraform command of meta
+func containerName(meta *TFConfigurationMeta, command string) string {
+	return fmt.Sprintf("tf-%s-%s-%s", meta.Namespace, meta.Name, command)
+}
+
+// run runs terraform with args in a container, which is removed once it exits. The container is killed when ctx is
+// canceled.
+func (e *ContainerExecutor) run(ctx context.Context, meta *TFConfigurationMeta, workspace, envFile string, args ...string) error {
+	name := containerName(meta, args[0])
+	// A container left by a controller which was killed would conflict with the name
+	exec.Command(e.Runtime, "rm", "--force", name).Run()
+
+	runArgs := []string{"run", "--rm", "--name", name,
+		"--volume", workspace + ":" + containerWorkspace, "--workdir", containerWorkspace,
+		"--env-file", envFile}
+	// The files of the workspace are owned by the controller, which removes them
+	if uid := os.Getuid(); uid >= 0 {
+		runArgs = append(runArgs, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+	}
+	if e.Network != "" {
+		runArgs = append(runArgs, "--network", e.Network)
+	}
+	runArgs = append(runArgs, meta.containerResourceArgs()...)
+	runArgs = append(runArgs, e.Image)
+	runArgs = append(runArgs, args...)
+
+	var output bytes.Buffer
+	cmd := exec.Command(e.Runtime, runArgs...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "failed to run the container %s", name)
+	}
+	// Killing the runtime CLI would leave the container running
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			if out, err := exec.Command(e.Runtime, "kill", name).CombinedOutput(); err != nil {
+				klog.ErrorS(err, "Failed to kill the terraform container", "Container", name, "Output", string(out))
+			}
+		}
+	}()
+	if err := cmd.Wait(); err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxContainerOutput {
+			out = "..." + out[len(out)-maxContainerOutput:]
+		}
+		return errors.Wrapf(err, "terraform %s failed in the container %s: %s", args[0], name, out)
+	}
+	klog.V(4).InfoS("Ran terraform in a container", "Container", name, "Output", output.String())
+	return nil
+}
+
+// containerResourceArgs returns the options of the container runtime for the resources set by the RESOURCES_* env
+// variables
+func (meta *TFConfigurationMeta) containerResourceArgs() []string {
+	var args []string
+	if meta.ResourcesLimitsCPU != "" {
+		args = append(args, "--cpus", strconv.FormatFloat(meta.ResourcesLimitsCPUQuantity.AsApproximateFloat64(), 'f', -1, 64))
+	}
+	if meta.ResourcesLimitsMemory != "" {
+		args = append(args, "--memory", strconv.FormatInt(meta.ResourcesLimitsMemoryQuantity.Value(), 10))
+	}
+	if meta.ResourcesRequestsCPU != "" {
+		// The CPU shares of a request, as the kubelet computes them
+		shares := meta.ResourcesRequestsCPUQuantity.MilliValue() * 1024 / 1000
+		if shares < 2 {
+			shares = 2
+		}
+		args = append(args, "--cpu-shares", strconv.FormatInt(shares, 10))
+	}
+	if meta.ResourcesRequestsMemory != "" {
+		args = append(args, "--memory-reservation", strconv.FormatInt(meta.ResourcesRequestsMemoryQuantity.Value(), 10))
+	}
+	return args
+}
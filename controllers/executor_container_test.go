@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttsubo2000/terraform-controller/controllers/provider"
+)
+
+func TestContainerEnvFile(t *testing.T) {
+	workspace := t.TempDir()
+	meta := &TFConfigurationMeta{
+		Name:               "c1",
+		VariableSecretData: map[string]string{"TF_VAR_name": "c1", "AWS_ACCESS_KEY_ID": "AKIARUN"},
+		CredentialFiles:    []provider.CredentialFile{{Name: "kubeconfig", EnvVar: "KUBE_CONFIG_PATH", Content: []byte("kind: Config")}},
+	}
+	envFile, remove, err := NewContainerExecutor().writeEnvFile(workspace, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the env file to only be readable by the controller, got %v", info.Mode())
+	}
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "AWS_ACCESS_KEY_ID=AKIARUN\nKUBE_CONFIG_PATH=/workspace/.credentials/kubeconfig\nTF_VAR_name=c1\n"
+	if string(content) != expected {
+		t.Errorf("expected the env of the run with the paths of the credential files in the containers, got %q", content)
+	}
+	if _, ok := os.LookupEnv("AWS_ACCESS_KEY_ID"); ok {
+		t.Error("expected the env of the run not to be set on the controller")
+	}
+	credentials, err := ioutil.ReadFile(filepath.Join(workspace, credentialsDirName, "kubeconfig"))
+	if err != nil || string(credentials) != "kind: Config" {
+		t.Errorf("expected the credential file in the workspace, got %q: %v", credentials, err)
+	}
+
+	remove()
+	if _, err := os.Stat(envFile); !os.IsNotExist(err) {
+		t.Errorf("expected the env file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, credentialsDirName)); !os.IsNotExist(err) {
+		t.Errorf("expected the credential files to be removed, got %v", err)
+	}
+
+	meta.VariableSecretData["TF_VAR_description"] = "two\nlines"
+	if _, _, err := NewContainerExecutor().writeEnvFile(workspace, meta); err == nil || !strings.Contains(err.Error(), "line break") {
+		t.Errorf("expected a variable with a line break to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ttsubo2000/terraform-controller/controllers/provider"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultTerraformImage is the image running terraform in the Jobs
+	DefaultTerraformImage = "hashicorp/terraform:1.2.6"
+	// DefaultBusyboxImage is the image copying the input configuration into the working volume of the Jobs
+	DefaultBusyboxImage = "busybox:1.35"
+	// DefaultGitImage is the image cloning the remote configurations into the working volume of the Jobs
+	DefaultGitImage = "alpine/git:latest"
+
+	// specHashAnnotation is the hash of what a Job runs, the Job is run again when it changes
+	specHashAnnotation = "terraform.core.oam.dev/spec-hash"
+	// configurationLabel is the label of the Jobs and their Pods with the name of their Configuration
+	configurationLabel = "terraform.core.oam.dev/configuration"
+	// backendOverrideFileName is the file of the Jobs which switches the backend to the kubernetes one
+	backendOverrideFileName = "terraform_controller_backend_override.tf"
+
+	workingVolumeName          = "tf-working"
+	credentialsVolumeName      = "tf-credentials"
+	credentialsVolumeMountPath = "/opt/tf-credentials"
+	gitCloneContainerName      = "git-configuration"
+	jobBackoffLimit            = int32(2)
+	// jobDeadlineExceeded is the reason of the failure of a Job which ran longer than its active deadline
+	jobDeadlineExceeded = "DeadlineExceeded"
+)
+
+// backendOverride is the backend of the Jobs, storing the state in the same Secret as the local runs
+var backendOverride = `terraform {
+  backend "kubernetes" {
+    secret_suffix     = %q
+    namespace         = %q
+    in_cluster_config = true
+  }
+}
+`
+
+// JobExecutor runs terraform in the Jobs of a Kubernetes cluster, in the namespace of the Configurations. The
+// state is stored in the backend Secret in the cluster, and copied into the store once a Job succeeded.
+type JobExecutor struct {
+	Client         kubernetes.Interface
+	TerraformImage string
+	BusyboxImage   string
+	GitImage       string
+}
+
+var _ Executor = &JobExecutor{}
+
+// NewJobExecutor returns the JobExecutor with the default images
+func NewJobExecutor(client kubernetes.Interface) *JobExecutor {
+	return &JobExecutor{
+		Client:         client,
+		TerraformImage: DefaultTerraformImage,
+		BusyboxImage:   DefaultBusyboxImage,
+		GitImage:       DefaultGitImage,
+	}
+}
+
+// Execute creates the Job of the run, and follows it until it succeeded. A Job is created again when the
+// configuration or the variables changed, or when it failed.
+func (e *JobExecutor) Execute(ctx context.Context, Client cacheObj.Store, meta *TFConfigurationMeta, executionType TerraformExecutionType) error {
+	name := meta.jobName(executionType)
+	files, err := e.inputFiles(Client, meta)
+	if err != nil {
+		return err
+	}
+	job := e.renderJob(meta, executionType, name)
+	hash, err := specHash(files, meta.VariableSecretData, meta.CredentialFiles, job.Spec)
+	if err != nil {
+		return err
+	}
+	job.Annotations = map[string]string{specHashAnnotation: hash}
+	// The timeouts are not hashed, so that changing them does not run a Job again
+	if deadline := meta.Timeouts.run(executionType); deadline > 0 {
+		seconds := int64(deadline.Seconds())
+		job.Spec.ActiveDeadlineSeconds = &seconds
+	}
+	gracePeriod := int64(meta.KillGracePeriod.Seconds())
+	job.Spec.Template.Spec.TerminationGracePeriodSeconds = &gracePeriod
+
+	jobs := e.Client.BatchV1().Jobs(meta.Namespace)
+	current, err := jobs.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if err := e.prepare(ctx, meta, files); err != nil {
+			return err
+		}
+		if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to create the terraform Job %s/%s", meta.Namespace, name)
+		}
+		klog.InfoS("Created the terraform Job", "Namespace", meta.Namespace, "Name", name)
+		return jobNotCompleted(executionType)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the terraform Job %s/%s", meta.Namespace, name)
+	}
+
+	switch {
+	case current.DeletionTimestamp != nil:
+		return jobNotCompleted(executionType)
+	case current.Spec.Suspend != nil && *current.Spec.Suspend:
+		// The Job of a canceled run is suspended, which terminates its Pod, then deleted
+		if err := e.deleteJob(ctx, meta.Namespace, name); err != nil {
+			return err
+		}
+		return &CanceledError{Command: string(executionType)}
+	case current.Annotations[specHashAnnotation] != hash:
+		klog.InfoS("The configuration or the variables changed, running the terraform Job again", "Namespace", meta.Namespace, "Name", name)
+		if err := e.deleteJob(ctx, meta.Namespace, name); err != nil {
+			return err
+		}
+		return jobNotCompleted(executionType)
+	}
+	if reason, message, failed := jobFailed(current); failed {
+		// The failed Job is deleted, for the next reconciliation to run it again
+		if err := e.deleteJob(ctx, meta.Namespace, name); err != nil {
+			klog.ErrorS(err, "Failed to delete the failed terraform Job", "Namespace", meta.Namespace, "Name", name)
+		}
+		err := errors.Errorf("the terraform Job %s/%s failed: %s", meta.Namespace, name, message)
+		if reason == jobDeadlineExceeded && current.Spec.ActiveDeadlineSeconds != nil {
+			deadline := time.Duration(*current.Spec.ActiveDeadlineSeconds) * time.Second
+			return &TimeoutError{Command: string(executionType), Timeout: deadline, Err: err}
+		}
+		return err
+	}
+	if current.Status.Succeeded == 0 {
+		return jobNotCompleted(executionType)
+	}
+
+	if err := e.copyTFState(ctx, Client, meta); err != nil {
+		return err
+	}
+	return meta.updateApplyStatus(ctx, Client, types.Available, types.MessageCloudResourceDeployed)
+}
+
+// CancelRun suspends the Job running terraform for the Configuration namespace/name, which terminates its Pod. The
+// next reconcile deletes the Job.
+func (e *JobExecutor) CancelRun(ctx context.Context, namespace, name string) (bool, error) {
+	meta := initTFConfigurationMeta(Request{NamespacedName: namespace + "/" + name},
+		&types.Configuration{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})
+	jobs := e.Client.BatchV1().Jobs(meta.Namespace)
+	canceled := false
+	for _, jobName := range []string{meta.ApplyJobName, meta.DestroyJobName} {
+		job, err := jobs.Get(ctx, jobName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to get the terraform Job %s/%s", meta.Namespace, jobName)
+		}
+		if _, _, failed := jobFailed(job); failed || job.Status.Succeeded > 0 || job.DeletionTimestamp != nil ||
+			(job.Spec.Suspend != nil && *job.Spec.Suspend) {
+			continue
+		}
+		patch := []byte(`{"spec":{"suspend":true}}`)
+		if _, err := jobs.Patch(ctx, jobName, k8stypes.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return false, errors.Wrapf(err, "failed to suspend the terraform Job %s/%s", meta.Namespace, jobName)
+		}
+		canceled = true
+	}
+	return canceled, nil
+}
+
+// Cleanup deletes the Jobs of meta, and their ConfigMap and Secrets
+func (e *JobExecutor) Cleanup(ctx context.Context, meta *TFConfigurationMeta) error {
+	for _, name := range []string{meta.ApplyJobName, meta.DestroyJobName} {
+		if err := e.deleteJob(ctx, meta.Namespace, name); err != nil {
+			return err
+		}
+	}
+	err := e.Client.CoreV1().ConfigMaps(meta.Namespace).Delete(ctx, fmt.Sprintf(TFInputConfigMapName, meta.Name), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete the input ConfigMap of the terraform Jobs")
+	}
+	secrets := map[string]string{
+		meta.VariableSecretName:      meta.Namespace,
+		meta.credentialsSecretName(): meta.Namespace,
+		meta.BackendSecretName:       meta.TerraformBackendNamespace,
+	}
+	for name, namespace := range secrets {
+		err := e.Client.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete the Secret %s/%s of the terraform Jobs", namespace, name)
+		}
+	}
+	return nil
+}
+
+// inputFiles returns the files of the working directory of the Jobs
+func (e *JobExecutor) inputFiles(Client cacheObj.Store, meta *TFConfigurationMeta) (map[string]string, error) {
+	data, err := meta.getTFConfigurationData(Client)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string, len(data)+2)
+	for k, v := range data {
+		files[k] = v
+	}
+	if meta.ProviderBlocks != "" {
+		files[providerBlocksFileName] = meta.ProviderBlocks
+	}
+	files[backendOverrideFileName] = fmt.Sprintf(backendOverride, meta.Name, meta.TerraformBackendNamespace)
+	return files, nil
+}
+
+// prepare creates or updates what the Job of meta needs in the cluster
+func (e *JobExecutor) prepare(ctx context.Context, meta *TFConfigurationMeta, files map[string]string) error {
+	if err := e.ensureServiceAccount(ctx, meta.Namespace); err != nil {
+		return err
+	}
+	if err := e.ensureClusterRole(ctx, meta.Namespace); err != nil {
+		return err
+	}
+	if err := e.applyConfigMap(ctx, meta.Namespace, fmt.Sprintf(TFInputConfigMapName, meta.Name), files); err != nil {
+		return err
+	}
+	if err := e.applySecret(ctx, meta.Namespace, meta.VariableSecretName, toSecretData(meta.VariableSecretData)); err != nil {
+		return err
+	}
+	if len(meta.CredentialFiles) == 0 {
+		return nil
+	}
+	credentials := make(map[string][]byte, len(meta.CredentialFiles))
+	for _, f := range meta.CredentialFiles {
+		credentials[f.Name] = f.Content
+	}
+	return e.applySecret(ctx, meta.Namespace, meta.credentialsSecretName(), credentials)
+}
+
+// renderJob returns the Job running terraform for meta
+func (e *JobExecutor) renderJob(meta *TFConfigurationMeta, executionType TerraformExecutionType, name string) *batchv1.Job {
+	workingDir := WorkingVolumeMountPath
+	var initContainers []v1.Container
+	if meta.ConfigurationType == types.ConfigurationRemote {
+		workingDir = path.Join(WorkingVolumeMountPath, meta.RemoteGitPath)
+		initContainers = append(initContainers, v1.Container{
+			Name:         gitCloneContainerName,
+			Image:        e.GitImage,
+			Command:      []string{"git", "clone", "--depth", "1", "--", meta.RemoteGit, WorkingVolumeMountPath},
+			VolumeMounts: []v1.VolumeMount{{Name: workingVolumeName, MountPath: WorkingVolumeMountPath}},
+		})
+	}
+	// The paths are passed in the environment rather than in the command, as the path of a remote configuration is
+	// set by the users
+	initContainers = append(initContainers, v1.Container{
+		Name:    terraformInitContainerName,
+		Image:   e.BusyboxImage,
+		Command: []string{"sh", "-c", `mkdir -p "$DST" && cp "$SRC"/* "$DST"/`},
+		Env: []v1.EnvVar{
+			{Name: "SRC", Value: InputTFConfigurationVolumeMountPath},
+			{Name: "DST", Value: workingDir},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: InputTFConfigurationVolumeName, MountPath: InputTFConfigurationVolumeMountPath, ReadOnly: true},
+			{Name: workingVolumeName, MountPath: WorkingVolumeMountPath},
+		},
+	})
+
+	env := append([]v1.EnvVar{{Name: "TF_DATA_DIR", Value: BackendVolumeMountPath}}, meta.Envs...)
+	volumeMounts := []v1.VolumeMount{
+		{Name: workingVolumeName, MountPath: WorkingVolumeMountPath},
+		{Name: BackendVolumeName, MountPath: BackendVolumeMountPath},
+	}
+	volumes := []v1.Volume{
+		{Name: InputTFConfigurationVolumeName, VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: fmt.Sprintf(TFInputConfigMapName, meta.Name)},
+		}}},
+		{Name: workingVolumeName, VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		{Name: BackendVolumeName, VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+	}
+	if len(meta.CredentialFiles) != 0 {
+		for _, f := range meta.CredentialFiles {
+			if f.EnvVar != "" {
+				env = append(env, v1.EnvVar{Name: f.EnvVar, Value: path.Join(credentialsVolumeMountPath, f.Name)})
+			}
+		}
+		volumeMounts = append(volumeMounts, v1.VolumeMount{Name: credentialsVolumeName, MountPath: credentialsVolumeMountPath, ReadOnly: true})
+		mode := int32(0400)
+		volumes = append(volumes, v1.Volume{Name: credentialsVolumeName, VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+			SecretName:  meta.credentialsSecretName(),
+			DefaultMode: &mode,
+		}}})
+	}
+
+	labels := map[string]string{configurationLabel: meta.Name}
+	backoffLimit := jobBackoffLimit
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: meta.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ServiceAccountName: ServiceAccountName,
+					RestartPolicy:      v1.RestartPolicyNever,
+					InitContainers:     initContainers,
+					Containers: []v1.Container{{
+						Name:         terraformContainerName,
+						Image:        e.TerraformImage,
+						WorkingDir:   workingDir,
+						Command:      []string{"sh", "-c", fmt.Sprintf("terraform init -input=false && terraform %s -auto-approve -input=false", executionType)},
+						Env:          env,
+						VolumeMounts: volumeMounts,
+						Resources:    meta.resourceRequirements(),
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// copyTFState copies the backend Secret written by the Job into the store
+func (e *JobExecutor) copyTFState(ctx context.Context, Client cacheObj.Store, meta *TFConfigurationMeta) error {
+	secret, err := e.Client.CoreV1().Secrets(meta.TerraformBackendNamespace).Get(ctx, meta.BackendSecretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the state Secret %s/%s of the terraform Job", meta.TerraformBackendNamespace, meta.BackendSecretName)
+	}
+	return Client.Add(&types.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meta.BackendSecretName,
+			Namespace: meta.TerraformBackendNamespace,
+		},
+		TypeMeta: metav1.TypeMeta{Kind: "Secret"},
+		Data:     secret.Data,
+	})
+}
+
+func (e *JobExecutor) deleteJob(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := e.Client.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete the terraform Job %s/%s", namespace, name)
+	}
+	return nil
+}
+
+func (e *JobExecutor) ensureServiceAccount(ctx context.Context, namespace string) error {
+	sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: ServiceAccountName, Namespace: namespace}}
+	_, err := e.Client.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, "failed to create the ServiceAccount of the terraform Jobs")
+	}
+	return nil
+}
+
+// ensureClusterRole creates the ClusterRole of the terraform runs, bound to the ServiceAccount of the namespace
+func (e *JobExecutor) ensureClusterRole(ctx context.Context, namespace string) error {
+	name := fmt.Sprintf("%s-%s", namespace, ClusterRoleName)
+	clusterRole := newTerraformExecutorClusterRole(name)
+	clusterRole.Namespace = ""
+	_, err := e.Client.RbacV1().ClusterRoles().Create(ctx, &clusterRole, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, "failed to create ClusterRole for Terraform executor")
+	}
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-binding"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: ServiceAccountName, Namespace: namespace}},
+	}
+	_, err = e.Client.RbacV1().ClusterRoleBindings().Create(ctx, binding, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, "failed to create ClusterRoleBinding for Terraform executor")
+	}
+	return nil
+}
+
+func (e *JobExecutor) applyConfigMap(ctx context.Context, namespace, name string, data map[string]string) error {
+	configMaps := e.Client.CoreV1().ConfigMaps(namespace)
+	current, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Data: data}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	case err == nil && !reflect.DeepEqual(current.Data, data):
+		current.Data = data
+		_, err = configMaps.Update(ctx, current, metav1.UpdateOptions{})
+	}
+	return errors.Wrapf(err, "failed to write the ConfigMap %s/%s", namespace, name)
+}
+
+func (e *JobExecutor) applySecret(ctx context.Context, namespace, name string, data map[string][]byte) error {
+	secrets := e.Client.CoreV1().Secrets(namespace)
+	current, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Data: data}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	case err == nil && !reflect.DeepEqual(current.Data, data):
+		current.Data = data
+		_, err = secrets.Update(ctx, current, metav1.UpdateOptions{})
+	}
+	return errors.Wrapf(err, "failed to write the Secret %s/%s", namespace, name)
+}
+
+// jobName returns the name of the Job of executionType
+func (meta *TFConfigurationMeta) jobName(executionType TerraformExecutionType) string {
+	if executionType == TerraformDestroy {
+		return meta.DestroyJobName
+	}
+	return meta.ApplyJobName
+}
+
+// credentialsSecretName returns the name of the Secret with the credential files of the Jobs
+func (meta *TFConfigurationMeta) credentialsSecretName() string {
+	return meta.VariableSecretName + "-credentials"
+}
+
+// resourceRequirements returns the resources of the terraform container set by the RESOURCES_* env variables
+func (meta *TFConfigurationMeta) resourceRequirements() v1.ResourceRequirements {
+	var resources v1.ResourceRequirements
+	if meta.ResourcesLimitsCPU != "" || meta.ResourcesLimitsMemory != "" {
+		resources.Limits = v1.ResourceList{}
+		if meta.ResourcesLimitsCPU != "" {
+			resources.Limits[v1.ResourceCPU] = meta.ResourcesLimitsCPUQuantity
+		}
+		if meta.ResourcesLimitsMemory != "" {
+			resources.Limits[v1.ResourceMemory] = meta.ResourcesLimitsMemoryQuantity
+		}
+	}
+	if meta.ResourcesRequestsCPU != "" || meta.ResourcesRequestsMemory != "" {
+		resources.Requests = v1.ResourceList{}
+		if meta.ResourcesRequestsCPU != "" {
+			resources.Requests[v1.ResourceCPU] = meta.ResourcesRequestsCPUQuantity
+		}
+		if meta.ResourcesRequestsMemory != "" {
+			resources.Requests[v1.ResourceMemory] = meta.ResourcesRequestsMemoryQuantity
+		}
+	}
+	return resources
+}
+
+// jobNotCompleted returns the error of a run which goes on
+func jobNotCompleted(executionType TerraformExecutionType) error {
+	if executionType == TerraformDestroy {
+		return errors.New(types.MessageDestroyJobNotCompleted)
+	}
+	return errors.New(types.MessageApplyJobNotCompleted)
+}
+
+// jobFailed returns whether the Job failed, and why
+func jobFailed(job *batchv1.Job) (reason string, message string, failed bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == v1.ConditionTrue {
+			return c.Reason, c.Message, true
+		}
+	}
+	return "", "", false
+}
+
+// specHash returns the hash of what a Job runs, the content of the credential files included
+func specHash(files map[string]string, variables map[string]string, credentialFiles []provider.CredentialFile, spec batchv1.JobSpec) (string, error) {
+	data, err := json.Marshal([]interface{}{files, variables, credentialFiles, spec})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to hash the terraform Job")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// newJobExecutorTest returns a JobExecutor on a fake clientset, and the store and meta of the Configuration default/c1
+func newJobExecutorTest(t *testing.T) (*JobExecutor, *fake.Clientset, cacheObj.Store, *TFConfigurationMeta) {
+	t.Helper()
+	store := cacheObj.NewStore(cacheObj.MetaNamespaceKeyFunc)
+	if err := store.Add(&types.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "tf-Configuration", Namespace: "default"},
+		Data:       map[string]string{"main.tf": `output "name" { value = "c1" }`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	configuration := &types.Configuration{
+		TypeMeta:   metav1.TypeMeta{Kind: "Configuration"},
+		ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "default"},
+	}
+	// Adding a Configuration queues its reconcile, which needs an informer, so it is updated into the store instead
+	if err := store.Update(configuration, false); err != nil {
+		t.Fatal(err)
+	}
+	meta := initTFConfigurationMeta(Request{NamespacedName: "default/c1"}, configuration)
+	meta.TerraformBackendNamespace = "vela-system"
+	meta.Timeouts = Timeouts{Init: time.Minute, Plan: time.Minute, Apply: time.Minute}
+	meta.KillGracePeriod = 30 * time.Second
+
+	client := fake.NewSimpleClientset()
+	return NewJobExecutor(client), client, store, meta
+}
+
+// setJobStatus sets the status of the apply Job of meta, like the Job controller
+func setJobStatus(t *testing.T, client *fake.Clientset, meta *TFConfigurationMeta, status batchv1.JobStatus) {
+	t.Helper()
+	jobs := client.BatchV1().Jobs(meta.Namespace)
+	job, err := jobs.Get(context.Background(), meta.ApplyJobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status = status
+	if _, err := jobs.UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func isJobNotCompleted(err error) bool {
+	return err != nil && err.Error() == types.MessageApplyJobNotCompleted
+}
+
+func TestJobExecutorCreatesTheJob(t *testing.T) {
+	e, client, store, meta := newJobExecutorTest(t)
+	ctx := context.Background()
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once created, got %v", err)
+	}
+	job, err := client.BatchV1().Jobs("default").Get(ctx, "c1-apply", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the Job to be created: %v", err)
+	}
+	if job.Annotations[specHashAnnotation] == "" {
+		t.Error("expected the Job to have the hash of its spec")
+	}
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != 180 {
+		t.Errorf("expected the active deadline to be the sum of the timeouts, got %v", job.Spec.ActiveDeadlineSeconds)
+	}
+	input, err := client.CoreV1().ConfigMaps("default").Get(ctx, "tf-c1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the input ConfigMap to be created: %v", err)
+	}
+	if input.Data["main.tf"] == "" || input.Data[backendOverrideFileName] == "" {
+		t.Errorf("expected the input ConfigMap to have the configuration and the backend override, got %v", input.Data)
+	}
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the running Job not to be completed, got %v", err)
+	}
+}
+
+func TestJobExecutorCopiesTheStateOfASucceededJob(t *testing.T) {
+	e, client, store, meta := newJobExecutorTest(t)
+	ctx := context.Background()
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once created, got %v", err)
+	}
+	state, err := util.CompressTerraformStateSecret([]byte(`{"outputs":{"name":{"value":"c1","type":"string"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CoreV1().Secrets("vela-system").Create(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: meta.BackendSecretName, Namespace: "vela-system"},
+		Data:       map[string][]byte{TerraformStateNameInSecret: state},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setJobStatus(t, client, meta, batchv1.JobStatus{Succeeded: 1})
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); err != nil {
+		t.Fatalf("expected the succeeded Job to complete the run, got %v", err)
+	}
+	obj, exists, err := store.GetByKey("Secret/vela-system/" + meta.BackendSecretName)
+	if err != nil || !exists {
+		t.Fatalf("expected the state to be copied into the store, got %v", err)
+	}
+	if string(obj.(*types.Secret).Data[TerraformStateNameInSecret]) != string(state) {
+		t.Error("expected the copied state to be the one of the Job")
+	}
+	obj, _, err = store.GetByKey("Configuration/default/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := obj.(*types.Configuration).Status.Apply
+	if apply.State != types.Available {
+		t.Fatalf("expected the Configuration to be Available, got %s: %s", apply.State, apply.Message)
+	}
+	if apply.Outputs["name"].Value != "c1" {
+		t.Errorf("expected the outputs of the state, got %v", apply.Outputs)
+	}
+}
+
+func TestJobExecutorDeletesTheJobWhenTheConfigurationChanged(t *testing.T) {
+	e, client, store, meta := newJobExecutorTest(t)
+	ctx := context.Background()
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once created, got %v", err)
+	}
+	meta.VariableSecretData = map[string]string{"name": "c2"}
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once the variables changed, got %v", err)
+	}
+	if _, err := client.BatchV1().Jobs("default").Get(ctx, "c1-apply", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the Job of the former variables to be deleted, got %v", err)
+	}
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job to be created again, got %v", err)
+	}
+	if _, err := client.BatchV1().Jobs("default").Get(ctx, "c1-apply", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected the Job of the new variables to be created: %v", err)
+	}
+}
+
+func TestJobExecutorDeletesAFailedJob(t *testing.T) {
+	e, client, store, meta := newJobExecutorTest(t)
+	ctx := context.Background()
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once created, got %v", err)
+	}
+	setJobStatus(t, client, meta, batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+		Type:    batchv1.JobFailed,
+		Status:  v1.ConditionTrue,
+		Reason:  "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	}}})
+
+	err := e.Execute(ctx, store, meta, TerraformApply)
+	if err == nil || !strings.Contains(err.Error(), "backoff limit") {
+		t.Fatalf("expected the failure of the Job, got %v", err)
+	}
+	if _, err := client.BatchV1().Jobs("default").Get(ctx, "c1-apply", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the failed Job to be deleted, got %v", err)
+	}
+}
+
+func TestJobExecutorReturnsATimeoutErrorOnTheDeadline(t *testing.T) {
+	e, client, store, meta := newJobExecutorTest(t)
+	ctx := context.Background()
+
+	if err := e.Execute(ctx, store, meta, TerraformApply); !isJobNotCompleted(err) {
+		t.Fatalf("expected the Job not to be completed once created, got %v", err)
+	}
+	setJobStatus(t, client, meta, batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+		Type:    batchv1.JobFailed,
+		Status:  v1.ConditionTrue,
+		Reason:  jobDeadlineExceeded,
+		Message: "Job was active longer than specified deadline",
+	}}})
+
+	err := e.Execute(ctx, store, meta, TerraformApply)
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	if timeout.Command != string(TerraformApply) || timeout.Timeout != 3*time.Minute {
+		t.Errorf("expected the timeout of the apply Job to be its deadline, got %s after %s", timeout.Command, timeout.Timeout)
+	}
+	if _, err := client.BatchV1().Jobs("default").Get(ctx, "c1-apply", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the failed Job to be deleted, got %v", err)
+	}
+}
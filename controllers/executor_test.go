@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	tfcfg "github.com/ttsubo2000/terraform-controller/controllers/configuration"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// addAWSProvider adds the aws Provider name, whose credentials are the access key accessKeyID
+func addAWSProvider(t *testing.T, store cacheObj.Store, name, accessKeyID string) *types.Provider {
+	t.Helper()
+	if err := store.Add(&types.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data: map[string][]byte{
+			"credentials": []byte("awsAccessKeyID: " + accessKeyID + "\nawsSecretAccessKey: secret-" + accessKeyID + "\n"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p := &types.Provider{
+		TypeMeta:   metav1.TypeMeta{Kind: "Provider"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+	}
+	p.Spec.Provider = "aws"
+	p.Spec.Region = "us-east-1"
+	p.Spec.Credentials.Source = crossplanetypes.CredentialsSourceSecret
+	p.Spec.Credentials.SecretRef = crossplanetypes.SecretKeySelector{
+		SecretReference: crossplanetypes.SecretReference{Name: name, Namespace: "default"},
+		Key:             "credentials",
+	}
+	return p
+}
+
+// runEnvOf returns the env of the run of the Configuration name, with providers
+func runEnvOf(t *testing.T, store cacheObj.Store, name string, refs []types.ProviderReference, providers []*types.Provider) map[string]string {
+	t.Helper()
+	configuration := &types.Configuration{
+		TypeMeta:   metav1.TypeMeta{Kind: "Configuration"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+	}
+	configuration.Spec.ProviderReferences = refs
+	configuration.Spec.Variable = &runtime.RawExtension{Raw: []byte(`{"name":"` + name + `"}`)}
+	meta := initTFConfigurationMeta(Request{NamespacedName: "default/" + name}, configuration)
+	meta.ProviderReferences = tfcfg.GetProviderReferences(configuration)
+	if err := meta.getCredentials(context.Background(), store, providers); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.prepareTFVariables(configuration); err != nil {
+		t.Fatal(err)
+	}
+	return meta.runEnv(nil)
+}
+
+func TestRunEnvOfAliasedProviders(t *testing.T) {
+	store := cacheObj.NewStore(cacheObj.MetaNamespaceKeyFunc)
+	requester := addAWSProvider(t, store, "aws-requester", "AKIAREQUESTER")
+	accepter := addAWSProvider(t, store, "aws-accepter", "AKIAACCEPTER")
+
+	env := runEnvOf(t, store, "c1", []types.ProviderReference{
+		{Name: "aws-requester", Region: "us-east-1"},
+		{Name: "aws-accepter", Alias: "accepter", Region: "eu-west-1"},
+	}, []*types.Provider{requester, accepter})
+	for k, v := range map[string]string{
+		"TF_VAR_name":                "c1",
+		"AWS_ACCESS_KEY_ID":          "AKIAREQUESTER",
+		"TF_VAR_tfc_accepter_region": "eu-west-1",
+	} {
+		if env[k] != v {
+			t.Errorf("expected %s=%s in the env of the run, got %q", k, v, env[k])
+		}
+	}
+	if env["TF_VAR_tfc_accepter_access_key"] != "AKIAACCEPTER" {
+		t.Errorf("expected the credentials of the aliased Provider in the env of the run, got %v", env)
+	}
+
+	// The env of a run is neither set on the controller nor passed to the runs of other Configurations
+	for k := range env {
+		if _, ok := os.LookupEnv(k); ok {
+			t.Errorf("expected %s not to be set on the controller", k)
+		}
+	}
+	env = runEnvOf(t, store, "c2", []types.ProviderReference{{Name: "aws-requester", Region: "us-east-1"}},
+		[]*types.Provider{requester})
+	if env["TF_VAR_name"] != "c2" {
+		t.Errorf("expected the variables of the Configuration c2, got %q", env["TF_VAR_name"])
+	}
+	for k := range env {
+		if strings.HasPrefix(k, "TF_VAR_tfc_") {
+			t.Errorf("expected no credentials of the aliased Provider of c1 in the env of c2, got %s", k)
+		}
+	}
+}
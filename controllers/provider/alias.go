@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
+	"github.com/pkg/errors"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// aliasedVariablePrefix prefixes the Terraform variables which configure the aliased provider blocks
+const aliasedVariablePrefix = "tfc_"
+
+// ProviderBlock is a Terraform provider block configured with credentials
+type ProviderBlock struct {
+	// Type is the Terraform provider type, like aws
+	Type string
+	// Arguments are the arguments of the block, like the access key
+	Arguments map[string]string
+	// FileArguments are the arguments which are set to the path of a file with the content, like a kubeconfig
+	FileArguments map[string][]byte
+	// Body is added to the block as is, like the features block of azurerm
+	Body string
+}
+
+// AliasedCredential is implemented by the Credentials which can configure an aliased provider block, so that a
+// Configuration can use several Providers of the same cloud provider
+type AliasedCredential interface {
+	// ProviderBlock returns the provider block configured with the credential in region
+	ProviderBlock(region string) (*ProviderBlock, error)
+}
+
+// GetAliasedProviderCredentials resolves the credentials of the provider into a provider block with alias. The
+// arguments of the block are sensitive Terraform variables prefixed by the alias, so that they never collide with the
+// environment variables of the other Providers of a Configuration.
+func GetAliasedProviderCredentials(ctx context.Context, Client cacheObj.Store, provider *types.Provider, alias, region string) (*Credentials, error) {
+	if provider.Spec.Credentials.Source == crossplanetypes.CredentialsSourceInjectedIdentity {
+		return nil, errors.Errorf("the provider %s with injected credentials can not configure the provider block %s", provider.Name, alias)
+	}
+	cred, err := getProviderCredential(ctx, Client, provider)
+	if err != nil {
+		return nil, err
+	}
+	aliased, ok := cred.(AliasedCredential)
+	if !ok {
+		return nil, errors.Errorf("the provider %s of %s does not support aliases", provider.Name, provider.Spec.Provider)
+	}
+	if _, err := refreshCredential(ctx, provider, cred, region); err != nil {
+		return nil, err
+	}
+	block, err := aliased.ProviderBlock(region)
+	if err != nil {
+		return nil, err
+	}
+	return renderProviderBlock(alias, block), nil
+}
+
+// renderProviderBlock renders the aliased provider block and its variables into HCL, and returns the environment
+// variables and files setting the variables
+func renderProviderBlock(alias string, block *ProviderBlock) *Credentials {
+	credentials := &Credentials{Env: map[string]string{}}
+	arguments := map[string]string{}
+	for name, value := range block.Arguments {
+		if value == "" {
+			// Unset arguments keep the default of the provider
+			continue
+		}
+		variable := aliasedVariablePrefix + alias + "_" + name
+		arguments[name] = variable
+		credentials.Env["TF_VAR_"+variable] = value
+	}
+	for name, content := range block.FileArguments {
+		variable := aliasedVariablePrefix + alias + "_" + name
+		arguments[name] = variable
+		credentials.Files = append(credentials.Files, CredentialFile{
+			Name:    variable,
+			EnvVar:  "TF_VAR_" + variable,
+			Content: content,
+		})
+	}
+	names := make([]string, 0, len(arguments))
+	for name := range arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var hcl strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&hcl, "variable %q {\n  type      = string\n  sensitive = true\n}\n\n", arguments[name])
+	}
+	width := len("alias")
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	fmt.Fprintf(&hcl, "provider %q {\n  %-*s = %q\n", block.Type, width, "alias", alias)
+	for _, name := range names {
+		fmt.Fprintf(&hcl, "  %-*s = var.%s\n", width, name, arguments[name])
+	}
+	if block.Body != "" {
+		hcl.WriteString(block.Body + "\n")
+	}
+	hcl.WriteString("}\n")
+	credentials.HCL = hcl.String()
+	return credentials
+}
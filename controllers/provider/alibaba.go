@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	envAlicloudAcessKey  = "ALICLOUD_ACCESS_KEY"
+	envAlicloudSecretKey = "ALICLOUD_SECRET_KEY"
+	envAlicloudRegion    = "ALICLOUD_REGION"
+	envAliCloudStsToken  = "ALICLOUD_SECURITY_TOKEN"
+)
+
+// AlibabaCloudCredentials are credentials for Alibaba Cloud
+type AlibabaCloudCredentials struct {
+	AccessKeyID     string `yaml:"accessKeyID"`
+	AccessKeySecret string `yaml:"accessKeySecret"`
+	SecurityToken   string `yaml:"securityToken"`
+	// STSEndpoint is the URL of the STS endpoint the credentials are checked against, by default the endpoint of
+	// the region
+	STSEndpoint string `yaml:"stsEndpoint,omitempty"`
+}
+
+func init() {
+	RegisterCredentialResolver(string(alibaba), yamlCredentialResolver(func() Credential { return &AlibabaCloudCredentials{} }))
+}
+
+// Validate checks the Alibaba Cloud credentials
+func (ak *AlibabaCloudCredentials) Validate() error {
+	return requireFields(
+		credentialField{"accessKeyID", ak.AccessKeyID},
+		credentialField{"accessKeySecret", ak.AccessKeySecret},
+	)
+}
+
+// Resolve returns the environment variables of the Alibaba Cloud provider
+func (ak *AlibabaCloudCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
+		envAlicloudAcessKey:  ak.AccessKeyID,
+		envAlicloudSecretKey: ak.AccessKeySecret,
+		envAlicloudRegion:    region,
+		envAliCloudStsToken:  ak.SecurityToken,
+	}}, nil
+}
+
+// Check calls GetCallerIdentity of Alibaba Cloud STS
+func (ak *AlibabaCloudCredentials) Check(ctx context.Context, region string) (string, error) {
+	return checkAlibabaCloudCredentials(region, ak.AccessKeyID, ak.AccessKeySecret, ak.SecurityToken, ak.STSEndpoint)
+}
+
+// checkAlibabaCloudProvider checks if the credentials from the provider are valid
+func checkAlibabaCloudCredentials(region string, accessKeyID, accessKeySecret, stsToken, endpoint string) (string, error) {
+	var (
+		client *sts.Client
+		err    error
+	)
+	if stsToken != "" {
+		client, err = sts.NewClientWithStsToken(region, accessKeyID, accessKeySecret, stsToken)
+	} else {
+		client, err = sts.NewClientWithAccessKey(region, accessKeyID, accessKeySecret)
+	}
+	if err != nil {
+		return "", err
+	}
+	request := sts.CreateGetCallerIdentityRequest()
+	request.Scheme = "https"
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return "", errors.Errorf("the STS endpoint %q is not a URL", endpoint)
+		}
+		request.Scheme = u.Scheme
+		request.Domain = u.Host
+	}
+
+	response, err := client.GetCallerIdentity(request)
+	if err != nil {
+		errMsg := "Alibaba Cloud credentials are invalid"
+		klog.ErrorS(err, errMsg)
+		return "", errors.Wrap(err, errMsg)
+	}
+	return response.Arn, nil
+}
+
+// ProviderBlock returns the block of the alicloud provider
+func (ak *AlibabaCloudCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "alicloud",
+		Arguments: map[string]string{
+			"access_key":     ak.AccessKeyID,
+			"secret_key":     ak.AccessKeySecret,
+			"security_token": ak.SecurityToken,
+			"region":         region,
+		},
+	}, nil
+}
@@ -1,9 +1,11 @@
 package provider
 
 import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
 )
 
 const (
@@ -11,6 +13,7 @@ const (
 	envAWSSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
 	envAWSDefaultRegion   = "AWS_DEFAULT_REGION"
 	envAWSSessionToken    = "AWS_SESSION_TOKEN"
+	envAWSSTSEndpoint     = "AWS_STS_ENDPOINT"
 )
 
 // AWSCredentials are credentials for AWS
@@ -18,18 +21,93 @@ type AWSCredentials struct {
 	AWSAccessKeyID     string `yaml:"awsAccessKeyID"`
 	AWSSecretAccessKey string `yaml:"awsSecretAccessKey"`
 	AWSSessionToken    string `yaml:"awsSessionToken"`
+	// STSEndpoint is the URL of the STS endpoint, by default the regional endpoint
+	STSEndpoint string `yaml:"stsEndpoint,omitempty"`
+
+	// RoleArn is the role assumed with the access keys, Terraform then uses the temporary credentials of the role
+	RoleArn     string `yaml:"roleArn,omitempty"`
+	ExternalID  string `yaml:"externalId,omitempty"`
+	SessionName string `yaml:"sessionName,omitempty"`
+	// Duration is how long the credentials of the role are valid, like 2h. It is between 15m and 12h, 1h by default
+	Duration string `yaml:"duration,omitempty"`
+
+	// session are the credentials of the assumed role
+	session *awsSessionCredentials
 }
 
-func getAWSCredentials(secretData []byte, name, namespace, region string) (map[string]string, error) {
-	var ak AWSCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
+// awsGetCallerIdentityResponse is the response of the STS GetCallerIdentity action
+type awsGetCallerIdentityResponse struct {
+	Arn     string `xml:"GetCallerIdentityResult>Arn"`
+	Account string `xml:"GetCallerIdentityResult>Account"`
+}
+
+func init() {
+	RegisterCredentialResolver(string(aws), yamlCredentialResolver(func() Credential { return &AWSCredentials{} }))
+}
+
+// Validate checks the AWS credentials
+func (ak *AWSCredentials) Validate() error {
+	if err := requireFields(
+		credentialField{"awsAccessKeyID", ak.AWSAccessKeyID},
+		credentialField{"awsSecretAccessKey", ak.AWSSecretAccessKey},
+	); err != nil {
+		return err
 	}
-	return map[string]string{
-		envAWSAccessKeyID:     ak.AWSAccessKeyID,
-		envAWSSecretAccessKey: ak.AWSSecretAccessKey,
-		envAWSSessionToken:    ak.AWSSessionToken,
+	return ak.validateAssumeRole()
+}
+
+// Resolve returns the environment variables of the AWS provider
+func (ak *AWSCredentials) Resolve(region string) (*Credentials, error) {
+	accessKeyID, secretAccessKey, sessionToken := ak.effective()
+	env := map[string]string{
+		envAWSAccessKeyID:     accessKeyID,
+		envAWSSecretAccessKey: secretAccessKey,
+		envAWSSessionToken:    sessionToken,
 		envAWSDefaultRegion:   region,
-	}, nil
+	}
+	if ak.STSEndpoint != "" {
+		env[envAWSSTSEndpoint] = ak.STSEndpoint
+	}
+	return &Credentials{Env: env}, nil
+}
+
+// Check calls GetCallerIdentity of AWS STS
+func (ak *AWSCredentials) Check(ctx context.Context, region string) (string, error) {
+	accessKeyID, secretAccessKey, sessionToken := ak.effective()
+	client := newAWSSTSClient(ak.STSEndpoint, region, accessKeyID, secretAccessKey, sessionToken)
+	body, err := client.call(ctx, "GetCallerIdentity", nil)
+	if err != nil {
+		return "", errors.Wrap(err, "AWS credentials are invalid")
+	}
+	var identity awsGetCallerIdentityResponse
+	if err := xml.Unmarshal(body, &identity); err != nil {
+		return "", errors.Wrap(err, "failed to decode the response of GetCallerIdentity")
+	}
+	return identity.Arn, nil
+}
+
+// ProviderBlock returns the block of the aws provider
+func (ak *AWSCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	accessKeyID, secretAccessKey, sessionToken := ak.effective()
+	block := &ProviderBlock{
+		Type: "aws",
+		Arguments: map[string]string{
+			"access_key": accessKeyID,
+			"secret_key": secretAccessKey,
+			"token":      sessionToken,
+			"region":     region,
+		},
+	}
+	if ak.STSEndpoint != "" {
+		block.Body = fmt.Sprintf("  endpoints {\n    sts = %q\n  }", ak.STSEndpoint)
+	}
+	return block, nil
+}
+
+// effective returns the credentials Terraform uses, which are those of the assumed role once it is assumed
+func (ak *AWSCredentials) effective() (accessKeyID, secretAccessKey, sessionToken string) {
+	if ak.session != nil {
+		return ak.session.AccessKeyID, ak.session.SecretAccessKey, ak.session.SessionToken
+	}
+	return ak.AWSAccessKeyID, ak.AWSSecretAccessKey, ak.AWSSessionToken
 }
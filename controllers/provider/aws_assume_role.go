@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	awsDefaultSessionName      = "terraform-controller"
+	awsDefaultAssumeRoleExpiry = time.Hour
+	awsMinAssumeRoleDuration   = 15 * time.Minute
+	awsMaxAssumeRoleDuration   = 12 * time.Hour
+)
+
+var awsSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// awsSessionCredentials are the temporary credentials of an assumed role
+type awsSessionCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// awsAssumeRoleResponse is the response of the STS AssumeRole action
+type awsAssumeRoleResponse struct {
+	Credentials awsSessionCredentials `xml:"AssumeRoleResult>Credentials"`
+}
+
+var (
+	// awsSessions caches the credentials of the assumed roles, by the checksum of what they were assumed with
+	awsSessions   = map[[sha256.Size]byte]*awsSessionCredentials{}
+	awsSessionsMu sync.Mutex
+)
+
+// validateAssumeRole checks the fields of the role to assume
+func (ak *AWSCredentials) validateAssumeRole() error {
+	if ak.RoleArn == "" {
+		if ak.ExternalID != "" || ak.SessionName != "" || ak.Duration != "" {
+			return errors.New("roleArn is required to set externalId, sessionName or duration")
+		}
+		return nil
+	}
+	if !strings.HasPrefix(ak.RoleArn, "arn:") {
+		return errors.Errorf("roleArn %q is not an ARN", ak.RoleArn)
+	}
+	if ak.SessionName != "" && !awsSessionNameRegexp.MatchString(ak.SessionName) {
+		return errors.Errorf("sessionName %q is not valid", ak.SessionName)
+	}
+	_, err := ak.assumeRoleDuration()
+	return err
+}
+
+func (ak *AWSCredentials) assumeRoleDuration() (time.Duration, error) {
+	if ak.Duration == "" {
+		return awsDefaultAssumeRoleExpiry, nil
+	}
+	duration, err := time.ParseDuration(ak.Duration)
+	if err != nil {
+		return 0, errors.Wrapf(err, "duration %q is not valid", ak.Duration)
+	}
+	if duration < awsMinAssumeRoleDuration || duration > awsMaxAssumeRoleDuration {
+		return 0, errors.Errorf("duration %s is not between %s and %s", ak.Duration, awsMinAssumeRoleDuration, awsMaxAssumeRoleDuration)
+	}
+	return duration, nil
+}
+
+// Refresh assumes the role, unless the credentials of the role assumed before are valid for at least half of their
+// duration, so that a run always starts with credentials which outlive it
+func (ak *AWSCredentials) Refresh(ctx context.Context, region string) (time.Time, error) {
+	if ak.RoleArn == "" {
+		return time.Time{}, nil
+	}
+	duration, err := ak.assumeRoleDuration()
+	if err != nil {
+		return time.Time{}, err
+	}
+	sessionName := ak.SessionName
+	if sessionName == "" {
+		sessionName = awsDefaultSessionName
+	}
+	key := sha256.Sum256([]byte(strings.Join([]string{ak.AWSAccessKeyID, ak.AWSSecretAccessKey, ak.AWSSessionToken,
+		ak.RoleArn, ak.ExternalID, sessionName, duration.String(), ak.STSEndpoint, region}, "\x00")))
+
+	awsSessionsMu.Lock()
+	defer awsSessionsMu.Unlock()
+	now := time.Now()
+	for k, session := range awsSessions {
+		if !session.Expiration.After(now) {
+			delete(awsSessions, k)
+		}
+	}
+	if session, ok := awsSessions[key]; ok && session.Expiration.Sub(now) > duration/2 {
+		ak.session = session
+		return session.Expiration, nil
+	}
+
+	params := url.Values{}
+	params.Set("RoleArn", ak.RoleArn)
+	params.Set("RoleSessionName", sessionName)
+	params.Set("DurationSeconds", strconv.Itoa(int(duration/time.Second)))
+	if ak.ExternalID != "" {
+		params.Set("ExternalId", ak.ExternalID)
+	}
+	client := newAWSSTSClient(ak.STSEndpoint, region, ak.AWSAccessKeyID, ak.AWSSecretAccessKey, ak.AWSSessionToken)
+	body, err := client.call(ctx, "AssumeRole", params)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to assume the role %s", ak.RoleArn)
+	}
+	var resp awsAssumeRoleResponse
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to decode the response of AssumeRole")
+	}
+	session := &resp.Credentials
+	if session.AccessKeyID == "" || session.SecretAccessKey == "" || session.Expiration.IsZero() {
+		return time.Time{}, errors.New("the response of AssumeRole has no credentials")
+	}
+	klog.InfoS("Assumed the AWS role", "Role", ak.RoleArn, "SessionName", sessionName, "Expiration", session.Expiration)
+	awsSessions[key] = session
+	ak.session = session
+	return session.Expiration, nil
+}
@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSTS is a local stand-in for AWS STS, which answers AssumeRole and GetCallerIdentity
+type fakeSTS struct {
+	*httptest.Server
+	// expiry is how long the credentials of the assumed roles are valid
+	expiry time.Duration
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newFakeSTS(t *testing.T, expiry time.Duration) *fakeSTS {
+	t.Helper()
+	sts := &fakeSTS{expiry: expiry}
+	sts.Server = httptest.NewServer(http.HandlerFunc(sts.serve))
+	t.Cleanup(sts.Close)
+	return sts
+}
+
+func (s *fakeSTS) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+
+	// The requests are signed with Signature V4, by the access key in the credential scope
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		http.Error(w, "<ErrorResponse><Error><Code>MissingAuthenticationToken</Code></Error></ErrorResponse>", http.StatusForbidden)
+		return
+	}
+	switch r.PostForm.Get("Action") {
+	case "AssumeRole":
+		if r.PostForm.Get("RoleArn") == "arn:aws:iam::123456789012:role/denied" {
+			http.Error(w, "<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ASIASESSION</AccessKeyId><SecretAccessKey>session-secret</SecretAccessKey>
+<SessionToken>session-token</SessionToken><Expiration>%s</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`, time.Now().Add(s.expiry).UTC().Format(time.RFC3339))
+	case "GetCallerIdentity":
+		fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult>
+<Arn>arn:aws:sts::123456789012:assumed-role/deployer/terraform-controller</Arn><Account>123456789012</Account>
+</GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+// calls returns the requests of action
+func (s *fakeSTS) calls(action string) []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []*http.Request
+	for _, r := range s.requests {
+		if r.PostForm.Get("Action") == action {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func TestAWSAssumeRole(t *testing.T) {
+	sts := newFakeSTS(t, time.Hour)
+	ak := &AWSCredentials{
+		AWSAccessKeyID:     "AKIASTATIC",
+		AWSSecretAccessKey: "static-secret",
+		STSEndpoint:        sts.URL,
+		RoleArn:            "arn:aws:iam::123456789012:role/deployer",
+		ExternalID:         "external",
+	}
+	if err := ak.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expiration, err := ak.Refresh(context.Background(), "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiration); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("expected the credentials to expire in an hour, got %s", until)
+	}
+	calls := sts.calls("AssumeRole")
+	if len(calls) != 1 {
+		t.Fatalf("expected AssumeRole to be called once, got %d", len(calls))
+	}
+	form := calls[0].PostForm
+	if form.Get("RoleArn") != ak.RoleArn || form.Get("ExternalId") != "external" ||
+		form.Get("RoleSessionName") != awsDefaultSessionName || form.Get("DurationSeconds") != "3600" {
+		t.Errorf("unexpected parameters of AssumeRole: %v", form)
+	}
+	if auth := calls[0].Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKIASTATIC/") ||
+		!strings.Contains(auth, "/eu-west-1/sts/aws4_request") {
+		t.Errorf("expected AssumeRole to be signed with the static keys in the region, got %q", auth)
+	}
+
+	// Terraform uses the credentials of the role
+	resolved, err := ak.Resolve("eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Env[envAWSAccessKeyID] != "ASIASESSION" || resolved.Env[envAWSSecretAccessKey] != "session-secret" ||
+		resolved.Env[envAWSSessionToken] != "session-token" {
+		t.Errorf("expected the environment of the assumed role, got %v", resolved.Env)
+	}
+	identity, err := ak.Check(context.Background(), "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "arn:aws:sts::123456789012:assumed-role/deployer/terraform-controller" {
+		t.Errorf("unexpected identity %q", identity)
+	}
+	check := sts.calls("GetCallerIdentity")[0]
+	if check.Header.Get("X-Amz-Security-Token") != "session-token" ||
+		!strings.Contains(check.Header.Get("Authorization"), "Credential=ASIASESSION/") {
+		t.Errorf("expected the check to be signed with the credentials of the role, got %v", check.Header)
+	}
+
+	// The credentials valid for more than half of their duration are reused
+	again := &AWSCredentials{
+		AWSAccessKeyID:     "AKIASTATIC",
+		AWSSecretAccessKey: "static-secret",
+		STSEndpoint:        sts.URL,
+		RoleArn:            "arn:aws:iam::123456789012:role/deployer",
+		ExternalID:         "external",
+	}
+	if _, err := again.Refresh(context.Background(), "eu-west-1"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := sts.calls("AssumeRole"); len(calls) != 1 {
+		t.Errorf("expected the credentials of the role to be reused, got %d calls of AssumeRole", len(calls))
+	}
+	if accessKeyID, _, _ := again.effective(); accessKeyID != "ASIASESSION" {
+		t.Errorf("expected the reused credentials of the role, got %s", accessKeyID)
+	}
+}
+
+func TestAWSAssumeRoleAgainPastHalfOfTheDuration(t *testing.T) {
+	// The credentials expire in 20 minutes, less than half of the duration of an hour
+	sts := newFakeSTS(t, 20*time.Minute)
+	for i := 0; i < 2; i++ {
+		ak := &AWSCredentials{
+			AWSAccessKeyID:     "AKIASTATIC",
+			AWSSecretAccessKey: "static-secret",
+			STSEndpoint:        sts.URL,
+			RoleArn:            "arn:aws:iam::123456789012:role/deployer",
+		}
+		if _, err := ak.Refresh(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := sts.calls("AssumeRole")
+	if len(calls) != 2 {
+		t.Fatalf("expected the role to be assumed again, got %d calls of AssumeRole", len(calls))
+	}
+	if auth := calls[0].Header.Get("Authorization"); !strings.Contains(auth, "/"+awsDefaultSTSRegion+"/sts/") {
+		t.Errorf("expected the default region of STS without region, got %q", auth)
+	}
+}
+
+func TestAWSAssumeRoleDenied(t *testing.T) {
+	sts := newFakeSTS(t, time.Hour)
+	ak := &AWSCredentials{
+		AWSAccessKeyID:     "AKIASTATIC",
+		AWSSecretAccessKey: "static-secret",
+		STSEndpoint:        sts.URL,
+		RoleArn:            "arn:aws:iam::123456789012:role/denied",
+	}
+	_, err := ak.Refresh(context.Background(), "eu-west-1")
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("expected the error of STS, got %v", err)
+	}
+	if accessKeyID, _, _ := ak.effective(); accessKeyID != "AKIASTATIC" {
+		t.Errorf("expected no credentials of the role, got %s", accessKeyID)
+	}
+}
+
+func TestAWSValidateAssumeRole(t *testing.T) {
+	for _, ak := range []*AWSCredentials{
+		{RoleArn: "deployer"},
+		{ExternalID: "external"},
+		{RoleArn: "arn:aws:iam::123456789012:role/deployer", SessionName: "a b"},
+		{RoleArn: "arn:aws:iam::123456789012:role/deployer", Duration: "5m"},
+		{RoleArn: "arn:aws:iam::123456789012:role/deployer", Duration: "13h"},
+	} {
+		ak.AWSAccessKeyID, ak.AWSSecretAccessKey = "AKIASTATIC", "static-secret"
+		if err := ak.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", ak)
+		}
+	}
+}
@@ -1,36 +1,216 @@
 package provider
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
+	"golang.org/x/crypto/pkcs12"
 )
 
 const (
-	envARMClientID       = "ARM_CLIENT_ID"
-	envARMClientSecret   = "ARM_CLIENT_SECRET"
-	envARMSubscriptionID = "ARM_SUBSCRIPTION_ID"
-	envARMTenantID       = "ARM_TENANT_ID"
+	envARMClientID                  = "ARM_CLIENT_ID"
+	envARMClientSecret              = "ARM_CLIENT_SECRET"
+	envARMSubscriptionID            = "ARM_SUBSCRIPTION_ID"
+	envARMTenantID                  = "ARM_TENANT_ID"
+	envARMClientCertificatePath     = "ARM_CLIENT_CERTIFICATE_PATH"
+	envARMClientCertificatePassword = "ARM_CLIENT_CERTIFICATE_PASSWORD"
+
+	azureClientCertificateFile = "azure-client-certificate.pfx"
+	azureDefaultAuthorityHost  = "https://login.microsoftonline.com"
+	azureManagementScope       = "https://management.azure.com/.default"
+	azureClientAssertionType   = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
 )
 
-// AzureCredentials are credentials for Azure
+// AzureCredentials are credentials for Azure. The service principal authenticates either with a client secret,
+// or with a client certificate.
 type AzureCredentials struct {
 	ARMClientID       string `yaml:"armClientID"`
 	ARMClientSecret   string `yaml:"armClientSecret"`
 	ARMSubscriptionID string `yaml:"armSubscriptionID"`
 	ARMTenantID       string `yaml:"armTenantID"`
+	// ARMClientCertificate is the base64 encoded PKCS#12 bundle of the client certificate and its private key
+	ARMClientCertificate         string `yaml:"armClientCertificate,omitempty"`
+	ARMClientCertificatePassword string `yaml:"armClientCertificatePassword,omitempty"`
+	// ARMAuthorityHost is the URL of the Azure Active Directory the credentials are checked against,
+	// by default the one of the public cloud
+	ARMAuthorityHost string `yaml:"armAuthorityHost,omitempty"`
 }
 
-func getAzureCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var cred AzureCredentials
-	if err := yaml.Unmarshal(secretData, &cred); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
+func init() {
+	RegisterCredentialResolver(string(azure), yamlCredentialResolver(func() Credential { return &AzureCredentials{} }))
+}
+
+// Validate checks the Azure credentials
+func (cred *AzureCredentials) Validate() error {
+	if err := requireFields(
+		credentialField{"armClientID", cred.ARMClientID},
+		credentialField{"armSubscriptionID", cred.ARMSubscriptionID},
+		credentialField{"armTenantID", cred.ARMTenantID},
+	); err != nil {
+		return err
+	}
+	switch {
+	case cred.ARMClientSecret == "" && cred.ARMClientCertificate == "":
+		return errors.New("either armClientSecret or armClientCertificate is required")
+	case cred.ARMClientSecret != "" && cred.ARMClientCertificate != "":
+		return errors.New("armClientSecret and armClientCertificate are mutually exclusive")
+	case cred.ARMClientCertificate != "":
+		if _, _, err := cred.clientCertificate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientCertificate decodes the client certificate and its private key
+func (cred *AzureCredentials) clientCertificate() (*rsa.PrivateKey, *x509.Certificate, error) {
+	pfx, err := base64.StdEncoding.DecodeString(cred.ARMClientCertificate)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "armClientCertificate is not base64 encoded")
+	}
+	key, cert, err := pkcs12.Decode(pfx, cred.ARMClientCertificatePassword)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "armClientCertificate is not a PKCS#12 bundle which can be decrypted with armClientCertificatePassword")
 	}
-	return map[string]string{
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("the private key of armClientCertificate is not an RSA key")
+	}
+	return rsaKey, cert, nil
+}
+
+// Resolve returns the environment variables of the Azure provider. A client certificate is written to a file.
+func (cred *AzureCredentials) Resolve(region string) (*Credentials, error) {
+	credentials := &Credentials{Env: map[string]string{
 		envARMClientID:       cred.ARMClientID,
-		envARMClientSecret:   cred.ARMClientSecret,
 		envARMSubscriptionID: cred.ARMSubscriptionID,
 		envARMTenantID:       cred.ARMTenantID,
-	}, nil
+	}}
+	if cred.ARMClientCertificate == "" {
+		credentials.Env[envARMClientSecret] = cred.ARMClientSecret
+		return credentials, nil
+	}
+	pfx, err := base64.StdEncoding.DecodeString(cred.ARMClientCertificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "armClientCertificate is not base64 encoded")
+	}
+	credentials.Env[envARMClientCertificatePassword] = cred.ARMClientCertificatePassword
+	credentials.Files = append(credentials.Files, CredentialFile{
+		Name:    azureClientCertificateFile,
+		EnvVar:  envARMClientCertificatePath,
+		Content: pfx,
+	})
+	return credentials, nil
+}
+
+// Check exchanges the client secret, or an assertion signed with the client certificate, for an Azure Resource
+// Manager token
+func (cred *AzureCredentials) Check(ctx context.Context, region string) (string, error) {
+	authorityHost := cred.ARMAuthorityHost
+	if authorityHost == "" {
+		authorityHost = azureDefaultAuthorityHost
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(cred.ARMTenantID))
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {cred.ARMClientID},
+		"scope":      {azureManagementScope},
+	}
+	if cred.ARMClientCertificate == "" {
+		form.Set("client_secret", cred.ARMClientSecret)
+	} else {
+		assertion, err := cred.clientAssertion(tokenURL)
+		if err != nil {
+			return "", err
+		}
+		form.Set("client_assertion_type", azureClientAssertionType)
+		form.Set("client_assertion", assertion)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrapf(err, "the authority host %q is not valid", authorityHost)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := doCheckRequest(req); err != nil {
+		return "", errors.Wrap(err, "Azure credentials are invalid")
+	}
+	return fmt.Sprintf("client %s of tenant %s", cred.ARMClientID, cred.ARMTenantID), nil
+}
+
+// clientAssertion returns a JWT signed with the client certificate, which authenticates the client to tokenURL
+func (cred *AzureCredentials) clientAssertion(tokenURL string) (string, error) {
+	key, cert, err := cred.clientCertificate()
+	if err != nil {
+		return "", err
+	}
+	thumbprint := sha1.Sum(cert.Raw)
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": tokenURL,
+		"iss": cred.ARMClientID,
+		"sub": cred.ARMClientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the client assertion")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ProviderBlock returns the block of the azurerm provider
+func (cred *AzureCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	block := &ProviderBlock{
+		Type: "azurerm",
+		Arguments: map[string]string{
+			"client_id":       cred.ARMClientID,
+			"subscription_id": cred.ARMSubscriptionID,
+			"tenant_id":       cred.ARMTenantID,
+		},
+		Body: "  features {}",
+	}
+	if cred.ARMClientCertificate == "" {
+		block.Arguments["client_secret"] = cred.ARMClientSecret
+		return block, nil
+	}
+	pfx, err := base64.StdEncoding.DecodeString(cred.ARMClientCertificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "armClientCertificate is not base64 encoded")
+	}
+	block.Arguments["client_certificate_password"] = cred.ARMClientCertificatePassword
+	block.FileArguments = map[string][]byte{"client_certificate_path": pfx}
+	return block, nil
 }
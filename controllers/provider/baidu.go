@@ -1,11 +1,5 @@
 package provider
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
-)
-
 const (
 	envBaiduAccessKey = "BAIDUCLOUD_ACCESS_KEY"
 	envBaiduSecretKey = "BAIDUCLOUD_SECRET_KEY"
@@ -18,15 +12,35 @@ type BaiduCloudCredentials struct {
 	KeyBaiduSecretKey string `yaml:"secretKey"`
 }
 
-func getBaiduCloudCredentials(secretData []byte, name, namespace, region string) (map[string]string, error) {
-	var ak BaiduCloudCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
-	}
-	return map[string]string{
+func init() {
+	RegisterCredentialResolver(string(baidu), yamlCredentialResolver(func() Credential { return &BaiduCloudCredentials{} }))
+}
+
+// Validate checks the Baidu Cloud credentials
+func (ak *BaiduCloudCredentials) Validate() error {
+	return requireFields(
+		credentialField{"accessKey", ak.KeyBaiduAccessKey},
+		credentialField{"secretKey", ak.KeyBaiduSecretKey},
+	)
+}
+
+// Resolve returns the environment variables of the Baidu Cloud provider
+func (ak *BaiduCloudCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envBaiduAccessKey: ak.KeyBaiduAccessKey,
 		envBaiduSecretKey: ak.KeyBaiduSecretKey,
 		envBaiduRegion:    region,
+	}}, nil
+}
+
+// ProviderBlock returns the block of the baiducloud provider
+func (ak *BaiduCloudCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "baiducloud",
+		Arguments: map[string]string{
+			"access_key": ak.KeyBaiduAccessKey,
+			"secret_key": ak.KeyBaiduSecretKey,
+			"region":     region,
+		},
 	}, nil
 }
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// envLiveCheck disables the live checks of the credentials when set to false
+const envLiveCheck = "PROVIDER_CREDENTIALS_LIVE_CHECK"
+
+// CredentialChecker is implemented by the Credentials which can be checked against their cloud provider
+type CredentialChecker interface {
+	// Check authenticates to the cloud provider with the credentials, and returns the authenticated identity
+	Check(ctx context.Context, region string) (identity string, err error)
+}
+
+// checkHTTPClient is the HTTP client of the live checks
+var checkHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+func liveCheckEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(envLiveCheck))
+	return err != nil || enabled
+}
+
+// credentialField is a field of the credentials, named as in the credentials data
+type credentialField struct {
+	name  string
+	value string
+}
+
+// requireFields returns an error naming the fields which are empty
+func requireFields(fields ...credentialField) error {
+	var missing []string
+	for _, f := range fields {
+		if strings.TrimSpace(f.value) == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return errors.Errorf("%s is required", missing[0])
+	default:
+		return errors.Errorf("%s are required", strings.Join(missing, ", "))
+	}
+}
+
+// doCheckRequest sends the request of a live check, and returns the response body of a successful response
+func doCheckRequest(req *http.Request) ([]byte, error) {
+	resp, err := checkHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the response of %s", req.URL)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned %s: %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// checkServer is a local stand-in for the API of a cloud provider, which records the requests of the live checks
+type checkServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	forms    []map[string][]string
+}
+
+// newCheckServer returns a checkServer answering the requests with handler, once their form is parsed
+func newCheckServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *checkServer {
+	t.Helper()
+	s := &checkServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") || r.Method == http.MethodGet {
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.forms = append(s.forms, r.Form)
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// last returns the last request, and its form
+func (s *checkServer) last(t *testing.T) (*http.Request, map[string][]string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		t.Fatal("expected a request of the live check")
+	}
+	return s.requests[len(s.requests)-1], s.forms[len(s.forms)-1]
+}
+
+func TestHashicupsCheck(t *testing.T) {
+	var signin map[string]string
+	server := newCheckServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/signin" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&signin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if signin["password"] != "test123" {
+			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"UserID": "1", "Username": signin["username"], "token": "t"})
+	})
+
+	cred := &HashicupsCredentials{HashicupsUser: "education", HashicupsPassword: "test123", HashicupsHost: server.URL + "/"}
+	identity, err := cred.Check(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "education" || signin["username"] != "education" {
+		t.Errorf("expected to sign in as education, got %q with %v", identity, signin)
+	}
+	if r, _ := server.last(t); r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON sign in, got %q", r.Header.Get("Content-Type"))
+	}
+
+	cred.HashicupsPassword = "wrong"
+	_, err = cred.Check(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "HashiCups credentials are invalid") || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the refused sign in to be reported, got %v", err)
+	}
+}
+
+func TestAzureCheck(t *testing.T) {
+	server := newCheckServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"})
+	})
+
+	cred := &AzureCredentials{
+		ARMClientID:       "client",
+		ARMClientSecret:   "secret",
+		ARMSubscriptionID: "subscription",
+		ARMTenantID:       "tenant",
+		ARMAuthorityHost:  server.URL,
+	}
+	identity, err := cred.Check(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "client client of tenant tenant" {
+		t.Errorf("unexpected identity %q", identity)
+	}
+	_, form := server.last(t)
+	for key, value := range map[string]string{
+		"grant_type": "client_credentials",
+		"client_id":  "client",
+		"scope":      azureManagementScope,
+	} {
+		if got := form[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("expected %s=%s in the token request, got %v", key, value, got)
+		}
+	}
+
+	cred.ARMClientSecret = "wrong"
+	_, err = cred.Check(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "Azure credentials are invalid") || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected the refused token request to be reported, got %v", err)
+	}
+}
+
+func TestAlibabaCloudCheck(t *testing.T) {
+	server := newCheckServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Form.Get("Action") != "GetCallerIdentity" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAction", "Message": "unknown action"})
+			return
+		}
+		if r.Form.Get("AccessKeyId") != "LTAIKEY" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAccessKeyId.NotFound", "Message": "Specified access key is not found."})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"RequestId":    "request",
+			"AccountId":    "1234567890",
+			"Arn":          "acs:ram::1234567890:user/terraform",
+			"IdentityType": "RAMUser",
+		})
+	})
+
+	ak := &AlibabaCloudCredentials{AccessKeyID: "LTAIKEY", AccessKeySecret: "secret", STSEndpoint: server.URL}
+	identity, err := ak.Check(context.Background(), "cn-hangzhou")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "acs:ram::1234567890:user/terraform" {
+		t.Errorf("unexpected identity %q", identity)
+	}
+	if _, form := server.last(t); len(form["Signature"]) != 1 {
+		t.Errorf("expected the request to be signed, got %v", form)
+	}
+
+	ak.AccessKeyID = "LTAIUNKNOWN"
+	_, err = ak.Check(context.Background(), "cn-hangzhou")
+	if err == nil || !strings.Contains(err.Error(), "Alibaba Cloud credentials are invalid") || !strings.Contains(err.Error(), "InvalidAccessKeyId.NotFound") {
+		t.Errorf("expected the refused access key to be reported, got %v", err)
+	}
+
+	ak.STSEndpoint = "sts.example.com"
+	if _, err := ak.Check(context.Background(), "cn-hangzhou"); err == nil || !strings.Contains(err.Error(), "is not a URL") {
+		t.Errorf("expected an endpoint without scheme to be rejected, got %v", err)
+	}
+}
+
+func TestAWSCheck(t *testing.T) {
+	server := newCheckServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.PostForm.Get("Action") != "GetCallerIdentity" || r.PostForm.Get("Version") != awsSTSAPIVersion {
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAKEY/") {
+			http.Error(w, "<ErrorResponse><Error><Code>InvalidClientTokenId</Code></Error></ErrorResponse>", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult>
+<Arn>arn:aws:iam::123456789012:user/terraform</Arn><Account>123456789012</Account>
+</GetCallerIdentityResult></GetCallerIdentityResponse>`))
+	})
+
+	ak := &AWSCredentials{AWSAccessKeyID: "AKIAKEY", AWSSecretAccessKey: "secret", AWSSessionToken: "token", STSEndpoint: server.URL}
+	identity, err := ak.Check(context.Background(), "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "arn:aws:iam::123456789012:user/terraform" {
+		t.Errorf("unexpected identity %q", identity)
+	}
+	r, _ := server.last(t)
+	if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") || !strings.Contains(auth, "/eu-west-1/sts/aws4_request") {
+		t.Errorf("expected a Signature V4 of the region, got %q", auth)
+	}
+	if r.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Errorf("expected the session token, got %q", r.Header.Get("X-Amz-Security-Token"))
+	}
+
+	ak.AWSAccessKeyID = "AKIAUNKNOWN"
+	_, err = ak.Check(context.Background(), "eu-west-1")
+	if err == nil || !strings.Contains(err.Error(), "AWS credentials are invalid") || !strings.Contains(err.Error(), "InvalidClientTokenId") {
+		t.Errorf("expected the refused access key to be reported, got %v", err)
+	}
+}
@@ -2,9 +2,11 @@ package provider
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
 
-	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
-	"github.com/ghodss/yaml"
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 
@@ -34,95 +36,125 @@ const (
 	custom    CloudProvider = "custom"
 	baidu     CloudProvider = "baidu"
 	hashicups CloudProvider = "hashicups"
+	k8s       CloudProvider = "kubernetes"
 )
 
 const (
-	envAlicloudAcessKey  = "ALICLOUD_ACCESS_KEY"
-	envAlicloudSecretKey = "ALICLOUD_SECRET_KEY"
-	envAlicloudRegion    = "ALICLOUD_REGION"
-	envAliCloudStsToken  = "ALICLOUD_SECURITY_TOKEN"
-
 	errConvertCredentials     = "failed to convert the credentials of Secret from Provider"
 	errCredentialValid        = "Credentials are not valid"
+	errCredentialRefresh      = "failed to exchange the credentials for temporary credentials"
 	ErrCredentialNotRetrieved = "Credentials are not retrieved from referenced Provider"
+
+	// MessageCredentialsValidated means the required fields of the credentials are set
+	MessageCredentialsValidated = "Credentials are validated"
+	// MessageCredentialsInjected means the credentials are not managed by the controller
+	MessageCredentialsInjected = "Credentials are injected by the environment of the controller"
+	// MessageCredentialsChecked means the cloud provider accepted the credentials
+	MessageCredentialsChecked = "Credentials are checked against the cloud provider"
 )
 
-// AlibabaCloudCredentials are credentials for Alibaba Cloud
-type AlibabaCloudCredentials struct {
-	AccessKeyID     string `yaml:"accessKeyID"`
-	AccessKeySecret string `yaml:"accessKeySecret"`
-	SecurityToken   string `yaml:"securityToken"`
+// GetProviderCredentials gets provider credentials, resolved by the CredentialResolver registered for its spec.provider
+func GetProviderCredentials(ctx context.Context, Client cacheObj.Store, provider *types.Provider, region string) (*Credentials, error) {
+	if provider.Spec.Credentials.Source == crossplanetypes.CredentialsSourceInjectedIdentity {
+		// Terraform inherits the environment of the controller
+		return &Credentials{Env: map[string]string{}}, nil
+	}
+	cred, err := getProviderCredential(ctx, Client, provider)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := refreshCredential(ctx, provider, cred, region); err != nil {
+		return nil, err
+	}
+	return cred.Resolve(region)
 }
 
-// GetProviderCredentials gets provider credentials by cloud provider name
-func GetProviderCredentials(ctx context.Context, Client cacheObj.Store, provider *types.Provider, region string) (map[string]string, error) {
-	switch provider.Spec.Credentials.Source {
-	case "Secret":
-		var secret *types.Secret
-		secretRef := provider.Spec.Credentials.SecretRef
-		name := secretRef.Name
-		namespace := secretRef.Namespace
-		key := "Secret" + "/" + namespace + "/" + name
-		obj, exists, err := Client.GetByKey(key)
-		if err != nil || !exists {
-			errMsg := "failed to get the Secret from Provider"
-			klog.ErrorS(err, errMsg, "key", key)
-			return nil, errors.Wrap(err, errMsg)
-		}
-		secret = obj.(*types.Secret)
-		secretData, ok := secret.Data[secretRef.Key]
-		if !ok {
-			return nil, errors.Errorf("in the provider %s, the key %s not found in the referenced secret %s", provider.Name, secretRef.Key, name)
-		}
-		SecretDataByte := []byte(secretData)
-		switch provider.Spec.Provider {
-		case string(alibaba):
-			var ak AlibabaCloudCredentials
-			if err := yaml.Unmarshal([]byte(secret.Data[secretRef.Key]), &ak); err != nil {
-				klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-				return nil, errors.Wrap(err, errConvertCredentials)
-			}
-			if err := checkAlibabaCloudCredentials(region, ak.AccessKeyID, ak.AccessKeySecret, ak.SecurityToken); err != nil {
-				klog.ErrorS(err, errCredentialValid)
-				return nil, errors.Wrap(err, errCredentialValid)
-			}
-			return map[string]string{
-				envAlicloudAcessKey:  ak.AccessKeyID,
-				envAlicloudSecretKey: ak.AccessKeySecret,
-				envAlicloudRegion:    region,
-				envAliCloudStsToken:  ak.SecurityToken,
-			}, nil
-		case string(ucloud):
-			return getUCloudCredentials(SecretDataByte, name, namespace)
-		case string(aws):
-			return getAWSCredentials(SecretDataByte, name, namespace, region)
-		case string(gcp):
-			return getGCPCredentials(SecretDataByte, name, namespace, region)
-		case string(tencent):
-			return getTencentCloudCredentials(SecretDataByte, name, namespace, region)
-		case string(azure):
-			return getAzureCredentials(SecretDataByte, name, namespace)
-		case string(vsphere):
-			return getVSphereCredentials(SecretDataByte, name, namespace)
-		case string(ec):
-			return getECCloudCredentials(SecretDataByte, name, namespace)
-		case string(custom):
-			return getCustomCredentials(SecretDataByte, name, namespace)
-		case string(baidu):
-			return getBaiduCloudCredentials(SecretDataByte, name, namespace, region)
-		case string(hashicups):
-			return getHashicupsCredentials(SecretDataByte, name, namespace)
-		default:
-			errMsg := "unsupported provider"
-			klog.InfoS(errMsg, "Provider", provider.Spec.Provider)
-			return nil, errors.New(errMsg)
-		}
-	default:
-		errMsg := "the credentials type is not supported."
-		err := errors.New(errMsg)
-		klog.ErrorS(err, "", "CredentialType", provider.Spec.Credentials.Source)
+// CheckProviderCredentials validates the credentials of the provider, and checks them against the cloud provider when
+// they implement CredentialChecker and live checks are enabled. It returns a message describing the outcome, and
+// when the credentials expire if they are temporary.
+func CheckProviderCredentials(ctx context.Context, Client cacheObj.Store, provider *types.Provider) (string, time.Time, error) {
+	if provider.Spec.Credentials.Source == crossplanetypes.CredentialsSourceInjectedIdentity {
+		return MessageCredentialsInjected, time.Time{}, nil
+	}
+	cred, err := getProviderCredential(ctx, Client, provider)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiry, err := refreshCredential(ctx, provider, cred, provider.Spec.Region)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	checker, ok := cred.(CredentialChecker)
+	if !ok {
+		return MessageCredentialsValidated, expiry, nil
+	}
+	if !liveCheckEnabled() {
+		return MessageCredentialsValidated + ", the live check is disabled", expiry, nil
+	}
+	identity, err := checker.Check(ctx, provider.Spec.Region)
+	if err != nil {
+		klog.ErrorS(err, errCredentialValid, "Provider", provider.Name)
+		return "", time.Time{}, errors.Wrap(err, errCredentialValid)
+	}
+	return fmt.Sprintf("%s, authenticated as %s", MessageCredentialsChecked, identity), expiry, nil
+}
+
+// refreshCredential exchanges the credential for temporary credentials when it implements CredentialRefresher
+func refreshCredential(ctx context.Context, provider *types.Provider, cred Credential, region string) (time.Time, error) {
+	refresher, ok := cred.(CredentialRefresher)
+	if !ok {
+		return time.Time{}, nil
+	}
+	expiry, err := refresher.Refresh(ctx, region)
+	if err != nil {
+		klog.ErrorS(err, errCredentialRefresh, "Provider", provider.Name)
+		return time.Time{}, errors.Wrap(err, errCredentialRefresh)
+	}
+	return expiry, nil
+}
+
+// credentialsNotFoundError means the credentials data of a Provider could not be read from its source
+type credentialsNotFoundError struct {
+	err error
+}
+
+func (e *credentialsNotFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *credentialsNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// IsCredentialsNotFound returns whether err means the credentials data of a Provider could not be read from its
+// source, like a missing Secret or environment variable
+func IsCredentialsNotFound(err error) bool {
+	var notFound *credentialsNotFoundError
+	return stderrors.As(err, &notFound)
+}
+
+// getProviderCredential parses and validates the credentials of the provider
+func getProviderCredential(ctx context.Context, Client cacheObj.Store, provider *types.Provider) (Credential, error) {
+	data, err := getCredentialsData(ctx, Client, provider)
+	if err != nil {
+		return nil, &credentialsNotFoundError{err: err}
+	}
+	resolver, ok := LookupCredentialResolver(provider.Spec.Provider)
+	if !ok {
+		errMsg := "unsupported provider"
+		klog.InfoS(errMsg, "Provider", provider.Spec.Provider, "Supported", RegisteredProviders())
+		return nil, errors.New(errMsg)
+	}
+	cred, err := resolver.Parse(data)
+	if err != nil {
+		klog.ErrorS(err, errConvertCredentials, "Provider", provider.Name, "Source", provider.Spec.Credentials.Source)
 		return nil, err
 	}
+	if err := cred.Validate(); err != nil {
+		klog.ErrorS(err, errCredentialValid, "Provider", provider.Name)
+		return nil, errors.Wrap(err, errCredentialValid)
+	}
+	return cred, nil
 }
 
 // GetProviderFromConfiguration gets provider object from Configuration
@@ -145,29 +177,3 @@ func GetProviderFromConfiguration(ctx context.Context, Client cacheObj.Store, na
 	provider = obj.(*types.Provider)
 	return provider, nil
 }
-
-// checkAlibabaCloudProvider checks if the credentials from the provider are valid
-func checkAlibabaCloudCredentials(region string, accessKeyID, accessKeySecret, stsToken string) error {
-	var (
-		client *sts.Client
-		err    error
-	)
-	if stsToken != "" {
-		client, err = sts.NewClientWithStsToken(region, accessKeyID, accessKeySecret, stsToken)
-	} else {
-		client, err = sts.NewClientWithAccessKey(region, accessKeyID, accessKeySecret)
-	}
-	if err != nil {
-		return err
-	}
-	request := sts.CreateGetCallerIdentityRequest()
-	request.Scheme = "https"
-
-	_, err = client.GetCallerIdentity(request)
-	if err != nil {
-		errMsg := "Alibaba Cloud credentials are invalid"
-		klog.ErrorS(err, errMsg)
-		return errors.Wrap(err, errMsg)
-	}
-	return nil
-}
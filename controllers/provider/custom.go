@@ -2,18 +2,30 @@ package provider
 
 import (
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // CustomCredentials are credentials for custom (you self)
 type CustomCredentials map[string]string
 
-func getCustomCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var ck = make(CustomCredentials)
-	if err := yaml.Unmarshal(secretData, &ck); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
+func init() {
+	RegisterCredentialResolver(string(custom), yamlCredentialResolver(func() Credential { return &CustomCredentials{} }))
+}
+
+// Validate checks the custom credentials are environment variables
+func (ck *CustomCredentials) Validate() error {
+	if len(*ck) == 0 {
+		return errors.New("at least one environment variable is required")
+	}
+	for name := range *ck {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return errors.Errorf("%q is not a valid environment variable name: %v", name, errs)
+		}
 	}
-	return ck, nil
+	return nil
+}
+
+// Resolve returns the custom credentials as environment variables
+func (ck *CustomCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: *ck}, nil
 }
@@ -1,11 +1,5 @@
 package provider
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
-)
-
 const (
 	envECApiKey = "EC_API_KEY"
 )
@@ -15,13 +9,26 @@ type ECCredentials struct {
 	ECApiKey string `yaml:"ecApiKey"`
 }
 
-func getECCloudCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var ak ECCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
-	}
-	return map[string]string{
+func init() {
+	RegisterCredentialResolver(string(ec), yamlCredentialResolver(func() Credential { return &ECCredentials{} }))
+}
+
+// Validate checks the Elastic Cloud credentials
+func (ak *ECCredentials) Validate() error {
+	return requireFields(credentialField{"ecApiKey", ak.ECApiKey})
+}
+
+// Resolve returns the environment variables of the Elastic Cloud provider
+func (ak *ECCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envECApiKey: ak.ECApiKey,
+	}}, nil
+}
+
+// ProviderBlock returns the block of the ec provider
+func (ak *ECCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type:      "ec",
+		Arguments: map[string]string{"apikey": ak.ECApiKey},
 	}, nil
 }
@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// WriteCredentialFiles writes the credential files of a run into a new temporary directory, readable by the owner
+// only. It returns the environment variables pointing at the files, and a cleanup function which removes them.
+func WriteCredentialFiles(runName string, files []CredentialFile) (map[string]string, func(), error) {
+	if len(files) == 0 {
+		return map[string]string{}, func() {}, nil
+	}
+
+	// TempDir creates the directory with the permissions 0700
+	dir, err := ioutil.TempDir("", "tf-credentials-"+runName+"-")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create the directory of the credential files")
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			klog.ErrorS(err, "Failed to remove the credential files", "Directory", dir)
+		}
+	}
+	env, err := WriteCredentialFilesIn(dir, files)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return env, cleanup, nil
+}
+
+// WriteCredentialFilesIn writes the credential files of a run into dir, readable by the owner only. It returns the
+// environment variables pointing at the files.
+func WriteCredentialFilesIn(dir string, files []CredentialFile) (map[string]string, error) {
+	env := make(map[string]string, len(files))
+	for _, f := range files {
+		if f.Name == "" || f.Name != filepath.Base(f.Name) || f.Name == "." || f.Name == ".." {
+			return nil, errors.Errorf("the credential file name %q is not a plain file name", f.Name)
+		}
+		path := filepath.Join(dir, f.Name)
+		if err := ioutil.WriteFile(path, f.Content, 0600); err != nil {
+			return nil, errors.Wrapf(err, "failed to write the credential file %s", f.Name)
+		}
+		if f.EnvVar != "" {
+			env[f.EnvVar] = path
+		}
+	}
+	return env, nil
+}
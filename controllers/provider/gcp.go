@@ -1,15 +1,17 @@
 package provider
 
 import (
+	"encoding/json"
+
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
 )
 
 const (
-	envGCPCredentialsJSON = "GOOGLE_CREDENTIALS"
-	envGCPRegion          = "GOOGLE_REGION"
-	envGCPProject         = "GOOGLE_PROJECT"
+	envGCPCredentials = "GOOGLE_CREDENTIALS"
+	envGCPRegion      = "GOOGLE_REGION"
+	envGCPProject     = "GOOGLE_PROJECT"
+
+	gcpCredentialsFile = "gcp-credentials.json"
 )
 
 // GCPCredentials are credentials for GCP
@@ -18,15 +20,48 @@ type GCPCredentials struct {
 	GCPProject         string `yaml:"gcpProject"`
 }
 
-func getGCPCredentials(secretData []byte, name, namespace, region string) (map[string]string, error) {
-	var ak GCPCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
+func init() {
+	RegisterCredentialResolver(string(gcp), yamlCredentialResolver(func() Credential { return &GCPCredentials{} }))
+}
+
+// Validate checks the GCP credentials
+func (ak *GCPCredentials) Validate() error {
+	if err := requireFields(
+		credentialField{"gcpCredentialsJSON", ak.GCPCredentialsJSON},
+		credentialField{"gcpProject", ak.GCPProject},
+	); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(ak.GCPCredentialsJSON)) {
+		return errors.New("gcpCredentialsJSON is not valid JSON")
 	}
-	return map[string]string{
-		envGCPCredentialsJSON: ak.GCPCredentialsJSON,
-		envGCPProject:         ak.GCPProject,
-		envGCPRegion:          region,
+	return nil
+}
+
+// Resolve returns the environment variables of the GCP provider. The service account key is written to a file,
+// GOOGLE_CREDENTIALS holds its path.
+func (ak *GCPCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{
+		Env: map[string]string{
+			envGCPProject: ak.GCPProject,
+			envGCPRegion:  region,
+		},
+		Files: []CredentialFile{{
+			Name:    gcpCredentialsFile,
+			EnvVar:  envGCPCredentials,
+			Content: []byte(ak.GCPCredentialsJSON),
+		}},
+	}, nil
+}
+
+// ProviderBlock returns the block of the google provider
+func (ak *GCPCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "google",
+		Arguments: map[string]string{
+			"project": ak.GCPProject,
+			"region":  region,
+		},
+		FileArguments: map[string][]byte{"credentials": []byte(ak.GCPCredentialsJSON)},
 	}, nil
 }
@@ -1,33 +1,84 @@
 package provider
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
 )
 
 const (
 	envHashicupsUser     = "HASHICUPS_USERNAME"
 	envHashicupsPassword = "HASHICUPS_PASSWORD"
 	envHashicupsHost     = "HASHICUPS_HOST"
+
+	// hashicupsDefaultHost is the default host of the HashiCups provider
+	hashicupsDefaultHost = "http://localhost:19090"
 )
 
-// VSphereCredentials are credentials for VSphere
+// HashicupsCredentials are credentials for HashiCups
 type HashicupsCredentials struct {
 	HashicupsUser     string `yaml:"HashicupsUser"`
 	HashicupsPassword string `yaml:"HashicupsPassword"`
 	HashicupsHost     string `yaml:"HashicupsHost"`
 }
 
-func getHashicupsCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var cred HashicupsCredentials
-	if err := yaml.Unmarshal(secretData, &cred); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
-	}
-	return map[string]string{
+func init() {
+	RegisterCredentialResolver(string(hashicups), yamlCredentialResolver(func() Credential { return &HashicupsCredentials{} }))
+}
+
+// Validate checks the HashiCups credentials
+func (cred *HashicupsCredentials) Validate() error {
+	return requireFields(
+		credentialField{"HashicupsUser", cred.HashicupsUser},
+		credentialField{"HashicupsPassword", cred.HashicupsPassword},
+	)
+}
+
+// Resolve returns the environment variables of the HashiCups provider
+func (cred *HashicupsCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envHashicupsUser:     cred.HashicupsUser,
 		envHashicupsPassword: cred.HashicupsPassword,
 		envHashicupsHost:     cred.HashicupsHost,
+	}}, nil
+}
+
+// Check signs in to the HashiCups API
+func (cred *HashicupsCredentials) Check(ctx context.Context, region string) (string, error) {
+	host := cred.HashicupsHost
+	if host == "" {
+		host = hashicupsDefaultHost
+	}
+	body, err := json.Marshal(map[string]string{
+		"username": cred.HashicupsUser,
+		"password": cred.HashicupsPassword,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(host, "/")+"/signin", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrapf(err, "the HashiCups host %q is not valid", host)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := doCheckRequest(req); err != nil {
+		return "", errors.Wrap(err, "HashiCups credentials are invalid")
+	}
+	return cred.HashicupsUser, nil
+}
+
+// ProviderBlock returns the block of the hashicups provider
+func (cred *HashicupsCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "hashicups",
+		Arguments: map[string]string{
+			"username": cred.HashicupsUser,
+			"password": cred.HashicupsPassword,
+			"host":     cred.HashicupsHost,
+		},
 	}, nil
 }
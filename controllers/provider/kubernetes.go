@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	envKubeConfigPath = "KUBE_CONFIG_PATH"
+	envKubeContext    = "KUBE_CTX"
+
+	kubeConfigFile = "kubeconfig"
+)
+
+// KubernetesCredentials are credentials for the Kubernetes provider
+type KubernetesCredentials struct {
+	// KubeConfig is the content of a kubeconfig file
+	KubeConfig string `yaml:"kubeconfig"`
+	// Context is the context of the kubeconfig to use, by default its current context
+	Context string `yaml:"context,omitempty"`
+}
+
+func init() {
+	RegisterCredentialResolver(string(k8s), yamlCredentialResolver(func() Credential { return &KubernetesCredentials{} }))
+}
+
+// Validate checks the kubeconfig can be loaded and has the context
+func (cred *KubernetesCredentials) Validate() error {
+	if err := requireFields(credentialField{"kubeconfig", cred.KubeConfig}); err != nil {
+		return err
+	}
+	config, err := clientcmd.Load([]byte(cred.KubeConfig))
+	if err != nil {
+		return errors.Wrap(err, "kubeconfig is not valid")
+	}
+	if cred.Context != "" {
+		if _, ok := config.Contexts[cred.Context]; !ok {
+			return errors.Errorf("the context %s is not found in kubeconfig", cred.Context)
+		}
+	}
+	return nil
+}
+
+// Resolve writes the kubeconfig to a file, KUBE_CONFIG_PATH holds its path
+func (cred *KubernetesCredentials) Resolve(region string) (*Credentials, error) {
+	credentials := &Credentials{
+		Env: map[string]string{},
+		Files: []CredentialFile{{
+			Name:    kubeConfigFile,
+			EnvVar:  envKubeConfigPath,
+			Content: []byte(cred.KubeConfig),
+		}},
+	}
+	if cred.Context != "" {
+		credentials.Env[envKubeContext] = cred.Context
+	}
+	return credentials, nil
+}
+
+// ProviderBlock returns the block of the kubernetes provider
+func (cred *KubernetesCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type:          "kubernetes",
+		Arguments:     map[string]string{"config_context": cred.Context},
+		FileArguments: map[string][]byte{"config_path": []byte(cred.KubeConfig)},
+	}, nil
+}
@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+// CredentialFile is a file Terraform reads credentials from, like a service account key
+type CredentialFile struct {
+	// Name is the file name
+	Name string
+	// EnvVar is the environment variable which is set to the path of the file
+	EnvVar  string
+	Content []byte
+}
+
+// Credentials are what Terraform needs to authenticate to a cloud provider
+type Credentials struct {
+	Env   map[string]string
+	Files []CredentialFile
+	// HCL configures the provider blocks of aliased Providers
+	HCL string
+}
+
+// Merge adds the credentials of another Provider, which must not set the same environment variables or files
+func (c *Credentials) Merge(other *Credentials) error {
+	if c.Env == nil {
+		c.Env = map[string]string{}
+	}
+	for k, v := range other.Env {
+		if _, exists := c.Env[k]; exists {
+			return errors.Errorf("the environment variable %s is set by the credentials of several Providers", k)
+		}
+		c.Env[k] = v
+	}
+	for _, f := range other.Files {
+		for _, existing := range c.Files {
+			if existing.Name == f.Name || (f.EnvVar != "" && existing.EnvVar == f.EnvVar) {
+				return errors.Errorf("the credential file %s is written for several Providers", f.Name)
+			}
+		}
+		c.Files = append(c.Files, f)
+	}
+	if other.HCL != "" {
+		if c.HCL != "" {
+			c.HCL += "\n"
+		}
+		c.HCL += other.HCL
+	}
+	return nil
+}
+
+// Credential is the parsed credentials data of a Provider
+type Credential interface {
+	// Validate checks the credential before it is used
+	Validate() error
+	// Resolve returns the environment variables and files for Terraform to use the credential in region
+	Resolve(region string) (*Credentials, error)
+}
+
+// CredentialRefresher is implemented by the Credentials which are exchanged for temporary credentials, like a role
+// to assume. Refresh is called before the credential is resolved or checked.
+type CredentialRefresher interface {
+	// Refresh exchanges the credential unless the former exchange is still fresh, and returns when it expires
+	Refresh(ctx context.Context, region string) (expiry time.Time, err error)
+}
+
+// CredentialResolver parses the credentials data of a Provider for one cloud provider
+type CredentialResolver interface {
+	Parse(data []byte) (Credential, error)
+}
+
+// CredentialResolverFunc is a CredentialResolver implemented by a function
+type CredentialResolverFunc func(data []byte) (Credential, error)
+
+// Parse calls f(data)
+func (f CredentialResolverFunc) Parse(data []byte) (Credential, error) {
+	return f(data)
+}
+
+// yamlCredentialResolver returns a CredentialResolver which unmarshals the YAML credentials data
+// into the Credential created by newCredential
+func yamlCredentialResolver(newCredential func() Credential) CredentialResolver {
+	return CredentialResolverFunc(func(data []byte) (Credential, error) {
+		cred := newCredential()
+		if err := yaml.Unmarshal(data, cred); err != nil {
+			return nil, errors.Wrap(err, errConvertCredentials)
+		}
+		return cred, nil
+	})
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]CredentialResolver{}
+)
+
+// RegisterCredentialResolver registers the CredentialResolver for the Providers whose spec.provider is name.
+// It is meant to be called from init functions, also by packages outside of this one. Registering a name twice
+// replaces the former resolver.
+func RegisterCredentialResolver(name string, resolver CredentialResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	if _, exists := resolvers[name]; exists {
+		klog.InfoS("Replacing the credential resolver", "Provider", name)
+	}
+	resolvers[name] = resolver
+}
+
+// LookupCredentialResolver returns the CredentialResolver registered for name
+func LookupCredentialResolver(name string) (CredentialResolver, bool) {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	resolver, ok := resolvers[name]
+	return resolver, ok
+}
+
+// RegisteredProviders returns the sorted names of the registered providers
+func RegisteredProviders() []string {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	names := make([]string, 0, len(resolvers))
+	for name := range resolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// defaultCredentialsFileInterval is how often the credentials files are checked for changes
+const defaultCredentialsFileInterval = 10 * time.Second
+
+var (
+	// CredentialsDir is the directory of the credentials files of the Filesystem source, which is disabled when it
+	// is empty
+	CredentialsDir string
+	// CredentialsEnvAllowlist are the environment variables of the Environment source, or their prefixes when they
+	// end with *. The source is disabled when it is empty.
+	CredentialsEnvAllowlist []string
+)
+
+// getCredentialsData reads the credentials data of the provider from its source
+func getCredentialsData(ctx context.Context, Client cacheObj.Store, provider *types.Provider) ([]byte, error) {
+	switch provider.Spec.Credentials.Source {
+	case crossplanetypes.CredentialsSourceSecret:
+		return getSecretKey(Client, provider, provider.Spec.Credentials.SecretRef)
+	case types.CredentialsSourceFilesystem:
+		fs := provider.Spec.Credentials.Fs
+		if fs == nil || fs.Path == "" {
+			return nil, errors.Errorf("in the provider %s, the path of the credentials file is not set", provider.Name)
+		}
+		path, err := credentialsFilePath(fs.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "in the provider %s", provider.Name)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			errMsg := "failed to read the credentials file from Provider"
+			klog.ErrorS(err, errMsg, "Path", fs.Path)
+			return nil, errors.Wrap(err, errMsg)
+		}
+		return data, nil
+	case types.CredentialsSourceEnvironment:
+		return getCredentialsFromEnvironment(provider)
+	case types.CredentialsSourceVault:
+		return getCredentialsFromVault(ctx, Client, provider)
+	default:
+		errMsg := "the credentials type is not supported."
+		err := errors.New(errMsg)
+		klog.ErrorS(err, "", "CredentialType", provider.Spec.Credentials.Source)
+		return nil, err
+	}
+}
+
+// getSecretKey reads the key of a Secret referenced by the provider
+func getSecretKey(Client cacheObj.Store, provider *types.Provider, secretRef crossplanetypes.SecretKeySelector) ([]byte, error) {
+	name := secretRef.Name
+	namespace := secretRef.Namespace
+	key := "Secret" + "/" + namespace + "/" + name
+	obj, exists, err := Client.GetByKey(key)
+	if err != nil {
+		errMsg := "failed to get the Secret from Provider"
+		klog.ErrorS(err, errMsg, "key", key)
+		return nil, errors.Wrap(err, errMsg)
+	}
+	if !exists {
+		return nil, errors.Errorf("in the provider %s, the referenced secret %s/%s is not found", provider.Name, namespace, name)
+	}
+	secret := obj.(*types.Secret)
+	secretData, ok := secret.Data[secretRef.Key]
+	if !ok {
+		return nil, errors.Errorf("in the provider %s, the key %s not found in the referenced secret %s", provider.Name, secretRef.Key, name)
+	}
+	return secretData, nil
+}
+
+// credentialsFilePath resolves the path of a credentials file of the Filesystem source, which must be in
+// CredentialsDir once its symlinks are resolved. A relative path is relative to CredentialsDir.
+func credentialsFilePath(path string) (string, error) {
+	if CredentialsDir == "" {
+		return "", errors.New("the Filesystem source is disabled, as the credentials directory of the controller is not set")
+	}
+	dir, err := filepath.Abs(CredentialsDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve the credentials directory")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return "", errors.Errorf("the path of the credentials file %s must not contain ..", path)
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	// The path is checked before its symlinks are resolved, so that no file outside of the directory is probed
+	if !isInDir(dir, filepath.Clean(path)) {
+		return "", errors.Errorf("the credentials file %s is not in the credentials directory", path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the credentials file")
+	}
+	if !isInDir(dir, resolved) {
+		return "", errors.Errorf("the credentials file %s links outside of the credentials directory", path)
+	}
+	return resolved, nil
+}
+
+// isInDir returns whether the clean absolute path is in dir
+func isInDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isAllowedEnv returns whether the environment variable name can be read by the Environment source
+func isAllowedEnv(name string) bool {
+	for _, allowed := range CredentialsEnvAllowlist {
+		if prefix := strings.TrimSuffix(allowed, "*"); prefix != allowed {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// getCredentialsFromEnvironment reads the credentials data from the environment variables of the controller
+func getCredentialsFromEnvironment(provider *types.Provider) ([]byte, error) {
+	env := provider.Spec.Credentials.Env
+	if env == nil || (env.Name == "" && len(env.Mapping) == 0) {
+		return nil, errors.Errorf("in the provider %s, the environment variables of the credentials are not set", provider.Name)
+	}
+	if env.Name != "" {
+		if !isAllowedEnv(env.Name) {
+			return nil, errors.Errorf("in the provider %s, the environment variable %s is not allowed for credentials", provider.Name, env.Name)
+		}
+		data, ok := os.LookupEnv(env.Name)
+		if !ok {
+			return nil, errors.Errorf("in the provider %s, the environment variable %s is not set", provider.Name, env.Name)
+		}
+		return []byte(data), nil
+	}
+
+	fields := make(map[string]string, len(env.Mapping))
+	for field, name := range env.Mapping {
+		if !isAllowedEnv(name) {
+			return nil, errors.Errorf("in the provider %s, the environment variable %s of %s is not allowed for credentials", provider.Name, name, field)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, errors.Errorf("in the provider %s, the environment variable %s of %s is not set", provider.Name, name, field)
+		}
+		fields[field] = value
+	}
+	return yaml.Marshal(fields)
+}
+
+// CredentialsFileWatcher reconciles the Providers whose credentials file changed, so that their status reflects
+// the new credentials
+type CredentialsFileWatcher struct {
+	Client cacheObj.Store
+	// Interval is how often the files are checked, 10 seconds by default
+	Interval time.Duration
+
+	// checksums are the checksums of the files when they were last checked
+	checksums map[string][sha256.Size]byte
+}
+
+// Start checks the credentials files until ctx is done
+func (w *CredentialsFileWatcher) Start(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultCredentialsFileInterval
+	}
+	w.checksums = map[string][sha256.Size]byte{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *CredentialsFileWatcher) check() {
+	providers := map[string][]*types.Provider{}
+	for _, obj := range w.Client.List() {
+		provider, ok := obj.(*types.Provider)
+		if !ok || provider.Spec.Credentials.Source != types.CredentialsSourceFilesystem || provider.Spec.Credentials.Fs == nil {
+			continue
+		}
+		path := provider.Spec.Credentials.Fs.Path
+		providers[path] = append(providers[path], provider)
+	}
+
+	paths := make([]string, 0, len(providers))
+	for path := range providers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		// A file which can not be read has an empty checksum, so that it changes again once it is readable
+		var data []byte
+		if resolved, err := credentialsFilePath(path); err == nil {
+			data, _ = ioutil.ReadFile(resolved)
+		}
+		checksum := sha256.Sum256(data)
+		previous, seen := w.checksums[path]
+		w.checksums[path] = checksum
+		if seen && previous == checksum {
+			continue
+		}
+		// The file may have changed since the Provider was reconciled, before it was first seen
+		klog.InfoS("Credentials file changed", "Path", path, "FirstSeen", !seen)
+		for _, provider := range providers[path] {
+			if err := w.Client.Update(provider, true); err != nil {
+				klog.ErrorS(err, "Failed to reconcile the Provider of a changed credentials file", "Provider", provider.Name)
+			}
+		}
+	}
+	for path := range w.checksums {
+		if _, ok := providers[path]; !ok {
+			delete(w.checksums, path)
+		}
+	}
+}
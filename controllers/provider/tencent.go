@@ -1,11 +1,5 @@
 package provider
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
-)
-
 const (
 	envQCloudSecretID  = "TENCENTCLOUD_SECRET_ID"
 	envQCloudSecretKey = "TENCENTCLOUD_SECRET_KEY"
@@ -18,15 +12,35 @@ type TencentCloudCredentials struct {
 	SecretKey string `yaml:"secretKey"`
 }
 
-func getTencentCloudCredentials(secretData []byte, name, namespace, region string) (map[string]string, error) {
-	var ak TencentCloudCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
-	}
-	return map[string]string{
+func init() {
+	RegisterCredentialResolver(string(tencent), yamlCredentialResolver(func() Credential { return &TencentCloudCredentials{} }))
+}
+
+// Validate checks the Tencent Cloud credentials
+func (ak *TencentCloudCredentials) Validate() error {
+	return requireFields(
+		credentialField{"secretID", ak.SecretID},
+		credentialField{"secretKey", ak.SecretKey},
+	)
+}
+
+// Resolve returns the environment variables of the Tencent Cloud provider
+func (ak *TencentCloudCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envQCloudSecretID:  ak.SecretID,
 		envQCloudSecretKey: ak.SecretKey,
 		envQCloudRegion:    region,
+	}}, nil
+}
+
+// ProviderBlock returns the block of the tencentcloud provider
+func (ak *TencentCloudCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "tencentcloud",
+		Arguments: map[string]string{
+			"secret_id":  ak.SecretID,
+			"secret_key": ak.SecretKey,
+			"region":     region,
+		},
 	}, nil
 }
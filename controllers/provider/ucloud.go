@@ -1,11 +1,5 @@
 package provider
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
-)
-
 const (
 	envUCloudPrivateKey = "UCLOUD_PRIVATE_KEY"
 	envUCloudProjectID  = "UCLOUD_PROJECT_ID"
@@ -21,16 +15,37 @@ type UCloudCredentials struct {
 	ProjectID  string `yaml:"projectID"`
 }
 
-func getUCloudCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var ak UCloudCredentials
-	if err := yaml.Unmarshal(secretData, &ak); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
-	}
-	return map[string]string{
+func init() {
+	RegisterCredentialResolver(string(ucloud), yamlCredentialResolver(func() Credential { return &UCloudCredentials{} }))
+}
+
+// Validate checks the UCloud credentials
+func (ak *UCloudCredentials) Validate() error {
+	return requireFields(
+		credentialField{"publicKey", ak.PublicKey},
+		credentialField{"privateKey", ak.PrivateKey},
+	)
+}
+
+// Resolve returns the environment variables of the UCloud provider
+func (ak *UCloudCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envUCloudPublicKey:  ak.PublicKey,
 		envUCloudPrivateKey: ak.PrivateKey,
 		envUCloudRegion:     ak.Region,
 		envUCloudProjectID:  ak.ProjectID,
+	}}, nil
+}
+
+// ProviderBlock returns the block of the ucloud provider
+func (ak *UCloudCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "ucloud",
+		Arguments: map[string]string{
+			"public_key":  ak.PublicKey,
+			"private_key": ak.PrivateKey,
+			"region":      ak.Region,
+			"project_id":  ak.ProjectID,
+		},
 	}, nil
 }
@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+const (
+	envVaultAddr  = "VAULT_ADDR"
+	envVaultToken = "VAULT_TOKEN"
+
+	vaultDefaultKVMount      = "secret"
+	vaultDefaultAppRoleMount = "approle"
+	// vaultDefaultSecretTTL is how long a secret without lease is cached, KV v2 secrets have none
+	vaultDefaultSecretTTL = 30 * time.Second
+	// vaultLeaseMargin is how long before the end of its lease a token or secret is not used anymore
+	vaultLeaseMargin = 10 * time.Second
+)
+
+// vaultHTTPClient is the HTTP client of the requests to Vault
+var vaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// vaultLease is a cached token or secret, valid until expiry
+type vaultLease struct {
+	token  string
+	data   map[string]interface{}
+	expiry time.Time
+}
+
+// vaultCache caches the tokens of the AppRole logins, and the secrets read, for the duration of their lease
+type vaultCache struct {
+	mu     sync.Mutex
+	leases map[[sha256.Size]byte]*vaultLease
+}
+
+var vaultLeases = &vaultCache{leases: map[[sha256.Size]byte]*vaultLease{}}
+
+func vaultCacheKey(parts ...string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+}
+
+func (c *vaultCache) get(key [sha256.Size]byte) *vaultLease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, lease := range c.leases {
+		if !lease.expiry.After(now) {
+			delete(c.leases, k)
+		}
+	}
+	return c.leases[key]
+}
+
+func (c *vaultCache) put(key [sha256.Size]byte, lease *vaultLease) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leases[key] = lease
+}
+
+func (c *vaultCache) delete(key [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.leases, key)
+}
+
+// leaseExpiry returns when a lease of leaseDuration seconds stops being used, or after ttl without lease
+func leaseExpiry(leaseDuration int, ttl time.Duration) time.Time {
+	if leaseDuration > 0 {
+		ttl = time.Duration(leaseDuration)*time.Second - vaultLeaseMargin
+	}
+	return time.Now().Add(ttl)
+}
+
+// vaultError is a response of Vault which is not successful
+type vaultError struct {
+	statusCode int
+	message    string
+}
+
+func (e *vaultError) Error() string {
+	return e.message
+}
+
+// vaultRequest sends a request to Vault, and decodes the JSON response into out
+func vaultRequest(ctx context.Context, method, address, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(address, "/")+"/v1/"+path, reader)
+	if err != nil {
+		return errors.Wrapf(err, "the Vault address %q is not valid", address)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := vaultHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the response of %s", req.URL)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &vaultError{
+			statusCode: resp.StatusCode,
+			message:    fmt.Sprintf("%s %s returned %s: %s", method, req.URL, resp.Status, strings.TrimSpace(string(data))),
+		}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return errors.Wrapf(err, "failed to decode the response of %s", req.URL)
+	}
+	return nil
+}
+
+// vaultLoginResponse is the response of a login
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+// vaultKVResponse is the response of a read of the KV v2 secrets engine
+type vaultKVResponse struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// getCredentialsFromVault reads the credentials data from a secret of a Vault KV v2 secrets engine
+func getCredentialsFromVault(ctx context.Context, Client cacheObj.Store, provider *types.Provider) ([]byte, error) {
+	selector := provider.Spec.Credentials.Vault
+	if selector == nil || selector.Path == "" {
+		return nil, errors.Errorf("in the provider %s, the path of the Vault secret is not set", provider.Name)
+	}
+	address := selector.Address
+	if address == "" {
+		address = os.Getenv(envVaultAddr)
+	}
+	if address == "" {
+		return nil, errors.Errorf("in the provider %s, the Vault address is not set, and %s is empty", provider.Name, envVaultAddr)
+	}
+	mount := selector.Mount
+	if mount == "" {
+		mount = vaultDefaultKVMount
+	}
+
+	data, err := readVaultSecret(ctx, Client, provider, address, mount)
+	if err != nil {
+		return nil, errors.Wrapf(err, "in the provider %s, failed to read the Vault secret %s/%s", provider.Name, mount, selector.Path)
+	}
+	if selector.Key == "" {
+		return yaml.Marshal(data)
+	}
+	value, ok := data[selector.Key]
+	if !ok {
+		return nil, errors.Errorf("in the provider %s, the key %s not found in the Vault secret %s/%s", provider.Name, selector.Key, mount, selector.Path)
+	}
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return yaml.Marshal(value)
+}
+
+// readVaultSecret reads the data of the secret, from the cache while its lease is valid. When the token of an
+// AppRole login is refused, it logs in again once.
+func readVaultSecret(ctx context.Context, Client cacheObj.Store, provider *types.Provider, address, mount string) (map[string]interface{}, error) {
+	selector := provider.Spec.Credentials.Vault
+	for attempt := 0; ; attempt++ {
+		token, tokenKey, err := vaultToken(ctx, Client, provider, address)
+		if err != nil {
+			return nil, err
+		}
+		secretKey := vaultCacheKey("secret", address, mount, selector.Path, token)
+		if lease := vaultLeases.get(secretKey); lease != nil {
+			return lease.data, nil
+		}
+
+		var resp vaultKVResponse
+		err = vaultRequest(ctx, http.MethodGet, address, mount+"/data/"+strings.TrimPrefix(selector.Path, "/"), token, nil, &resp)
+		var vErr *vaultError
+		if errors.As(err, &vErr) && vErr.statusCode == http.StatusForbidden && tokenKey != nil && attempt == 0 {
+			klog.InfoS("The Vault token is refused, logging in again", "Provider", provider.Name)
+			vaultLeases.delete(*tokenKey)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Data.Data == nil {
+			return nil, errors.New("the secret has no data, it may be deleted")
+		}
+		vaultLeases.put(secretKey, &vaultLease{data: resp.Data.Data, expiry: leaseExpiry(resp.LeaseDuration, vaultDefaultSecretTTL)})
+		return resp.Data.Data, nil
+	}
+}
+
+// vaultToken returns the token of the provider. The tokens of AppRole logins are cached for their lease, the key of
+// the cached token is returned so that it can be dropped.
+func vaultToken(ctx context.Context, Client cacheObj.Store, provider *types.Provider, address string) (string, *[sha256.Size]byte, error) {
+	auth := provider.Spec.Credentials.Vault.Auth
+	switch {
+	case auth.TokenSecretRef != nil:
+		token, err := getSecretKey(Client, provider, *auth.TokenSecretRef)
+		return strings.TrimSpace(string(token)), nil, err
+	case auth.AppRole != nil:
+		secretID, err := getSecretKey(Client, provider, auth.AppRole.SecretIDRef)
+		if err != nil {
+			return "", nil, err
+		}
+		mount := auth.AppRole.Mount
+		if mount == "" {
+			mount = vaultDefaultAppRoleMount
+		}
+		key := vaultCacheKey("approle", address, mount, auth.AppRole.RoleID, string(secretID))
+		if lease := vaultLeases.get(key); lease != nil {
+			return lease.token, &key, nil
+		}
+		var resp vaultLoginResponse
+		body := map[string]string{"role_id": auth.AppRole.RoleID, "secret_id": strings.TrimSpace(string(secretID))}
+		if err := vaultRequest(ctx, http.MethodPost, address, "auth/"+mount+"/login", "", body, &resp); err != nil {
+			return "", nil, errors.Wrap(err, "failed to log in with AppRole")
+		}
+		if resp.Auth.ClientToken == "" {
+			return "", nil, errors.New("the AppRole login returned no token")
+		}
+		klog.InfoS("Logged in to Vault with AppRole", "Provider", provider.Name, "LeaseDuration", resp.Auth.LeaseDuration)
+		// A token without lease does not expire, it is still logged in again from time to time
+		vaultLeases.put(key, &vaultLease{token: resp.Auth.ClientToken, expiry: leaseExpiry(resp.Auth.LeaseDuration, time.Hour)})
+		return resp.Auth.ClientToken, &key, nil
+	default:
+		// The token of the controller is only sent to its own Vault, not to an address set by the Provider
+		if strings.TrimSuffix(address, "/") != strings.TrimSuffix(os.Getenv(envVaultAddr), "/") {
+			return "", nil, errors.Errorf("the Vault auth is not set, and %s is only sent to %s, not to %s",
+				envVaultToken, envVaultAddr, address)
+		}
+		token := os.Getenv(envVaultToken)
+		if token == "" {
+			return "", nil, errors.Errorf("the Vault auth is not set, and %s is empty", envVaultToken)
+		}
+		return token, nil, nil
+	}
+}
@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// fakeVault is a local stand-in for Vault, with the AppRole auth method and a KV v2 secrets engine
+type fakeVault struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	tokens    map[string]bool
+	logins    int
+	reads     int
+	lastToken string
+}
+
+func newFakeVault(t *testing.T, tokens ...string) *fakeVault {
+	t.Helper()
+	vault := &fakeVault{tokens: map[string]bool{}}
+	for _, token := range tokens {
+		vault.tokens[token] = true
+	}
+	vault.Server = httptest.NewServer(http.HandlerFunc(vault.serve))
+	t.Cleanup(vault.Close)
+	return vault
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login":
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role_id"] != "role" || body["secret_id"] != "secret-id" {
+			http.Error(w, `{"errors":["invalid role or secret ID"]}`, http.StatusBadRequest)
+			return
+		}
+		v.logins++
+		token := fmt.Sprintf("approle-token-%d", v.logins)
+		v.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": token, "lease_duration": 3600}})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		v.reads++
+		v.lastToken = r.Header.Get("X-Vault-Token")
+		if !v.tokens[r.Header.Get("X-Vault-Token")] {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		data := map[string]interface{}{"awsAccessKeyID": "AKIAVAULT", "awsSecretAccessKey": "vault-secret"}
+		if r.URL.Path == "/v1/secret/data/packed" {
+			data = map[string]interface{}{"credentials": "awsAccessKeyID: AKIAPACKED\nawsSecretAccessKey: packed-secret\n"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// revoke makes Vault refuse the tokens it issued
+func (v *fakeVault) revoke() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens = map[string]bool{}
+}
+
+func (v *fakeVault) counts() (logins, reads int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.logins, v.reads
+}
+
+// token returns the token of the last read
+func (v *fakeVault) token() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.lastToken
+}
+
+// newVaultProvider returns a Provider reading its credentials from the Vault secret path, and the store of its Secrets
+func newVaultProvider(t *testing.T, selector *types.VaultSelector, secretData map[string][]byte) (cacheObj.Store, *types.Provider) {
+	t.Helper()
+	store := cacheObj.NewStore(cacheObj.MetaNamespaceKeyFunc)
+	if err := store.Add(&types.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "default"},
+		Data:       secretData,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	provider := &types.Provider{ObjectMeta: metav1.ObjectMeta{Name: "aws", Namespace: "default"}}
+	provider.Spec.Credentials.Source = types.CredentialsSourceVault
+	provider.Spec.Credentials.Vault = selector
+	return store, provider
+}
+
+func vaultSecretRef(key string) crossplanetypes.SecretKeySelector {
+	return crossplanetypes.SecretKeySelector{
+		SecretReference: crossplanetypes.SecretReference{Name: "vault", Namespace: "default"},
+		Key:             key,
+	}
+}
+
+func TestVaultTokenSecretRef(t *testing.T) {
+	vault := newFakeVault(t, "static-token")
+	ref := vaultSecretRef("token")
+	store, provider := newVaultProvider(t, &types.VaultSelector{
+		Address: vault.URL,
+		Path:    "packed",
+		Key:     "credentials",
+		Auth:    types.VaultAuth{TokenSecretRef: &ref},
+	}, map[string][]byte{"token": []byte("static-token\n")})
+
+	for i := 0; i < 2; i++ {
+		data, err := getCredentialsFromVault(context.Background(), store, provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "AKIAPACKED") {
+			t.Errorf("expected the credentials of the key of the secret, got %q", data)
+		}
+	}
+	if _, reads := vault.counts(); reads != 1 {
+		t.Errorf("expected the secret to be read once, then cached, got %d reads", reads)
+	}
+	if token := vault.token(); token != "static-token" {
+		t.Errorf("expected the token of the Secret without its newline, got %q", token)
+	}
+}
+
+func TestVaultAppRole(t *testing.T) {
+	vault := newFakeVault(t)
+	store, provider := newVaultProvider(t, &types.VaultSelector{
+		Address: vault.URL,
+		Path:    "aws",
+		Auth: types.VaultAuth{AppRole: &types.VaultAppRole{
+			RoleID:      "role",
+			SecretIDRef: vaultSecretRef("secret-id"),
+		}},
+	}, map[string][]byte{"secret-id": []byte("secret-id")})
+
+	data, err := getCredentialsFromVault(context.Background(), store, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "awsAccessKeyID: AKIAVAULT") {
+		t.Errorf("expected the keys of the secret to be the fields of the credentials, got %q", data)
+	}
+	if logins, _ := vault.counts(); logins != 1 {
+		t.Fatalf("expected one login, got %d", logins)
+	}
+
+	// The token is cached for its lease, and the login is done again once Vault refuses it
+	vault.revoke()
+	provider.Spec.Credentials.Vault.Path = "aws-other"
+	if _, err := getCredentialsFromVault(context.Background(), store, provider); err != nil {
+		t.Fatal(err)
+	}
+	logins, reads := vault.counts()
+	if logins != 2 || reads != 3 {
+		t.Errorf("expected a login again after the refused read, got %d logins and %d reads", logins, reads)
+	}
+	if token := vault.token(); token != "approle-token-2" {
+		t.Errorf("expected the token of the new login, got %q", token)
+	}
+}
+
+func TestVaultAppRoleInvalidSecretID(t *testing.T) {
+	vault := newFakeVault(t)
+	store, provider := newVaultProvider(t, &types.VaultSelector{
+		Address: vault.URL,
+		Path:    "aws",
+		Auth: types.VaultAuth{AppRole: &types.VaultAppRole{
+			RoleID:      "role",
+			SecretIDRef: vaultSecretRef("secret-id"),
+		}},
+	}, map[string][]byte{"secret-id": []byte("wrong")})
+
+	_, err := getCredentialsFromVault(context.Background(), store, provider)
+	if err == nil || !strings.Contains(err.Error(), "failed to log in with AppRole") {
+		t.Fatalf("expected the login to fail, got %v", err)
+	}
+}
+
+func TestVaultTokenOfTheController(t *testing.T) {
+	vault := newFakeVault(t, "controller-token")
+	t.Setenv(envVaultAddr, vault.URL+"/")
+	t.Setenv(envVaultToken, "controller-token")
+
+	store, provider := newVaultProvider(t, &types.VaultSelector{Path: "aws"}, nil)
+	if _, err := getCredentialsFromVault(context.Background(), store, provider); err != nil {
+		t.Fatalf("expected VAULT_TOKEN to be sent to VAULT_ADDR, got %v", err)
+	}
+	if token := vault.token(); token != "controller-token" {
+		t.Errorf("expected VAULT_TOKEN, got %q", token)
+	}
+
+	// VAULT_TOKEN is not sent to the address set by a Provider
+	other := newFakeVault(t)
+	store, provider = newVaultProvider(t, &types.VaultSelector{Address: other.URL, Path: "aws"}, nil)
+	_, err := getCredentialsFromVault(context.Background(), store, provider)
+	if err == nil || !strings.Contains(err.Error(), "only sent to VAULT_ADDR") {
+		t.Fatalf("expected VAULT_TOKEN to be refused for another address, got %v", err)
+	}
+	if _, reads := other.counts(); reads != 0 {
+		t.Errorf("expected no request to the other address, got %d", reads)
+	}
+}
@@ -1,9 +1,9 @@
 package provider
 
 import (
+	"strconv"
+
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
-	"k8s.io/klog/v2"
 )
 
 const (
@@ -21,16 +21,46 @@ type VSphereCredentials struct {
 	VSphereAllowUnverifiedSSL string `yaml:"vSphereAllowUnverifiedSSL,omitempty"`
 }
 
-func getVSphereCredentials(secretData []byte, name, namespace string) (map[string]string, error) {
-	var cred VSphereCredentials
-	if err := yaml.Unmarshal(secretData, &cred); err != nil {
-		klog.ErrorS(err, errConvertCredentials, "Name", name, "Namespace", namespace)
-		return nil, errors.Wrap(err, errConvertCredentials)
+func init() {
+	RegisterCredentialResolver(string(vsphere), yamlCredentialResolver(func() Credential { return &VSphereCredentials{} }))
+}
+
+// Validate checks the VSphere credentials
+func (cred *VSphereCredentials) Validate() error {
+	if err := requireFields(
+		credentialField{"vSphereUser", cred.VSphereUser},
+		credentialField{"vSpherePassword", cred.VSpherePassword},
+		credentialField{"vSphereServer", cred.VSphereServer},
+	); err != nil {
+		return err
+	}
+	if cred.VSphereAllowUnverifiedSSL != "" {
+		if _, err := strconv.ParseBool(cred.VSphereAllowUnverifiedSSL); err != nil {
+			return errors.Errorf("vSphereAllowUnverifiedSSL must be true or false, not %q", cred.VSphereAllowUnverifiedSSL)
+		}
 	}
-	return map[string]string{
+	return nil
+}
+
+// Resolve returns the environment variables of the VSphere provider
+func (cred *VSphereCredentials) Resolve(region string) (*Credentials, error) {
+	return &Credentials{Env: map[string]string{
 		envVSphereUser:               cred.VSphereUser,
 		envVSpherePassword:           cred.VSpherePassword,
 		envVSphereServer:             cred.VSphereServer,
 		envVSphereAllowUnverifiedSSL: cred.VSphereAllowUnverifiedSSL,
+	}}, nil
+}
+
+// ProviderBlock returns the block of the vsphere provider
+func (cred *VSphereCredentials) ProviderBlock(region string) (*ProviderBlock, error) {
+	return &ProviderBlock{
+		Type: "vsphere",
+		Arguments: map[string]string{
+			"user":                 cred.VSphereUser,
+			"password":             cred.VSpherePassword,
+			"vsphere_server":       cred.VSphereServer,
+			"allow_unverified_ssl": cred.VSphereAllowUnverifiedSSL,
+		},
 	}, nil
 }
@@ -3,13 +3,17 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
 	"github.com/pkg/errors"
 	"github.com/ttsubo/client-go/tools/cache"
 	providercred "github.com/ttsubo2000/terraform-controller/controllers/provider"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/tools/record"
 	"github.com/ttsubo2000/terraform-controller/types"
 )
 
@@ -18,8 +22,19 @@ const (
 	errSettingStatus  = "failed to set status"
 )
 
+// Reasons of the conditions of a Provider
+const (
+	reasonSecretFound        = "SecretFound"
+	reasonSecretNotFound     = "SecretNotFound"
+	reasonCredentialsValid   = "CredentialsValid"
+	reasonCredentialsInvalid = "CredentialsInvalid"
+)
+
 type ProviderReconciler struct {
-	Client cacheObj.Store
+	Client   cacheObj.Store
+	Recorder record.EventRecorder
+	// RevalidationInterval is how often the credentials of a Provider are validated again, never when zero
+	RevalidationInterval time.Duration
 }
 
 func (r *ProviderReconciler) Reconcile(ctx context.Context, req Request, indexer cache.Indexer) (Result, error) {
@@ -33,10 +48,31 @@ func (r *ProviderReconciler) Reconcile(ctx context.Context, req Request, indexer
 		return Result{}, err
 	}
 	provider := obj.(*types.Provider)
+	// The Provider may have been deleted from the store since it was queued, like for its revalidation
+	if _, exists, _ := r.Client.Get(provider); !exists {
+		klog.InfoS("The Provider was deleted", "NamespacedName", req.NamespacedName)
+		return Result{}, nil
+	}
 
-	if _, err := providercred.GetProviderCredentials(ctx, r.Client, provider, provider.Spec.Region); err != nil {
+	message, expiry, err := providercred.CheckProviderCredentials(ctx, r.Client, provider)
+	now := metav1.Now()
+	if err != nil {
 		provider.Status.State = types.ProviderIsNotReady
 		provider.Status.Message = fmt.Sprintf("%s: %s", errGetCredentials, err.Error())
+		provider.Status.CredentialsExpiry = nil
+		if providercred.IsCredentialsNotFound(err) {
+			r.Recorder.Event(provider, v1.EventTypeWarning, reasonSecretNotFound, err.Error())
+			provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderSecretFound, Status: metav1.ConditionFalse,
+				LastValidatedTime: now, Reason: reasonSecretNotFound, Message: err.Error()})
+			provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderCredentialsValid, Status: metav1.ConditionUnknown,
+				LastValidatedTime: now, Reason: reasonSecretNotFound})
+		} else {
+			r.Recorder.Event(provider, v1.EventTypeWarning, reasonCredentialsInvalid, err.Error())
+			provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderSecretFound, Status: metav1.ConditionTrue,
+				LastValidatedTime: now, Reason: reasonSecretFound})
+			provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderCredentialsValid, Status: metav1.ConditionFalse,
+				LastValidatedTime: now, Reason: reasonCredentialsInvalid, Message: err.Error()})
+		}
 		klog.ErrorS(err, errGetCredentials, "Provider", req.NamespacedName)
 		if updateErr := r.Client.Update(provider, false); updateErr != nil {
 			klog.ErrorS(updateErr, errSettingStatus, "Provider", req.NamespacedName)
@@ -45,13 +81,39 @@ func (r *ProviderReconciler) Reconcile(ctx context.Context, req Request, indexer
 		return Result{}, errors.Wrap(err, errGetCredentials)
 	}
 
-	provider.Status = types.ProviderStatus{
-		State: types.ProviderIsReady,
+	provider.Status.State = types.ProviderIsReady
+	provider.Status.Message = message
+	provider.Status.CredentialsExpiry = nil
+	if !expiry.IsZero() {
+		provider.Status.CredentialsExpiry = &metav1.Time{Time: expiry}
+	}
+	if condition := provider.Status.GetCondition(types.ProviderCredentialsValid); condition == nil || condition.Status != metav1.ConditionTrue {
+		r.Recorder.Event(provider, v1.EventTypeNormal, reasonCredentialsValid, message)
 	}
+	provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderSecretFound, Status: metav1.ConditionTrue,
+		LastValidatedTime: now, Reason: reasonSecretFound})
+	provider.Status.SetCondition(types.ProviderCondition{Type: types.ProviderCredentialsValid, Status: metav1.ConditionTrue,
+		LastValidatedTime: now, Reason: reasonCredentialsValid, Message: message})
 	if updateErr := r.Client.Update(provider, false); updateErr != nil {
 		klog.ErrorS(updateErr, errSettingStatus, "Provider", req.NamespacedName)
 		return Result{}, errors.Wrap(updateErr, errSettingStatus)
 	}
 
-	return Result{}, nil
+	requeueAfter := r.RevalidationInterval
+	if !expiry.IsZero() {
+		// The credentials are refreshed before they expire, the status then has the new expiry
+		if interval := credentialsRefreshInterval(expiry); requeueAfter == 0 || interval < requeueAfter {
+			requeueAfter = interval
+		}
+	}
+	return Result{RequeueAfter: requeueAfter}, nil
+}
+
+// credentialsRefreshInterval is how long to wait before refreshing credentials which expire at expiry
+func credentialsRefreshInterval(expiry time.Time) time.Duration {
+	interval := time.Until(expiry) / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	return interval
 }
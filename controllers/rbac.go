@@ -9,8 +9,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func createTerraformExecutorClusterRole(ctx context.Context, Client cacheObj.Store, clusterRoleName string) error {
-	var clusterRole = rbacv1.ClusterRole{
+// newTerraformExecutorClusterRole returns the ClusterRole of the terraform runs, which store their state and lock
+func newTerraformExecutorClusterRole(clusterRoleName string) rbacv1.ClusterRole {
+	return rbacv1.ClusterRole{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "rbac.authorization.k8s.io/v1",
 			Kind:       "ClusterRole",
@@ -32,6 +33,10 @@ func createTerraformExecutorClusterRole(ctx context.Context, Client cacheObj.Sto
 			},
 		},
 	}
+}
+
+func createTerraformExecutorClusterRole(ctx context.Context, Client cacheObj.Store, clusterRoleName string) error {
+	var clusterRole = newTerraformExecutorClusterRole(clusterRoleName)
 	key := "ClusterRole" + "/" + "Default" + "/" + clusterRoleName
 	_, exists, err := Client.GetByKey(key)
 	if err != nil || !exists {
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
+)
+
+// shutdownKey is the key of the context of the controller in the context of the reconciles
+type shutdownKey struct{}
+
+// detachedContext keeps the values of its parent, but is never canceled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// reconcileContext returns the context of the reconciles of a controller stopped by ctx. It is not canceled when
+// the controller stops, so that a terraform run in progress is interrupted rather than killed, and can persist its
+// state. The process exits once the grace period of the manager is over.
+func reconcileContext(ctx context.Context) context.Context {
+	return context.WithValue(detachedContext{parent: ctx}, shutdownKey{}, ctx)
+}
+
+// shutdownSignal returns a channel closed when the controller running the reconcile of ctx stops
+func shutdownSignal(ctx context.Context) <-chan struct{} {
+	if stop, ok := ctx.Value(shutdownKey{}).(context.Context); ok {
+		return stop.Done()
+	}
+	return ctx.Done()
+}
+
+// checkShutdown returns an error once the controller running the reconcile of ctx stops, so that no terraform
+// command is started anymore
+func checkShutdown(ctx context.Context) error {
+	select {
+	case <-shutdownSignal(ctx):
+		return errors.New("the controller is stopping")
+	default:
+		return nil
+	}
+}
+
+// interruptOnShutdown sends SIGINT to the terraform processes when the controller stops before stop is called, so
+// that terraform persists its state and releases its lock
+func interruptOnShutdown(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-shutdownSignal(ctx):
+			count, err := util.InterruptChildProcesses()
+			if err != nil {
+				klog.ErrorS(err, "Failed to interrupt terraform")
+				return
+			}
+			klog.InfoS("Interrupted terraform, waiting for it to persist its state", "Processes", count)
+		}
+	}()
+	return func() { close(done) }
+}
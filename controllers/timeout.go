@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/ttsubo2000/terraform-controller/metrics"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+const (
+	// DefaultInitTimeout is how long terraform init runs by default
+	DefaultInitTimeout = 10 * time.Minute
+	// DefaultPlanTimeout is how long terraform plan runs by default
+	DefaultPlanTimeout = 30 * time.Minute
+	// DefaultApplyTimeout is how long terraform apply runs by default
+	DefaultApplyTimeout = time.Hour
+	// DefaultDestroyTimeout is how long terraform destroy runs by default
+	DefaultDestroyTimeout = time.Hour
+	// DefaultKillGracePeriod is how long an interrupted terraform has to persist its state before it is killed
+	DefaultKillGracePeriod = 30 * time.Second
+)
+
+// Timeouts are the longest durations of the terraform commands, a zero one is unlimited
+type Timeouts struct {
+	Init    time.Duration
+	Plan    time.Duration
+	Apply   time.Duration
+	Destroy time.Duration
+}
+
+// withSpec returns the timeouts with those set in the spec of a Configuration
+func (t Timeouts) withSpec(spec *types.Timeouts) Timeouts {
+	if spec == nil {
+		return t
+	}
+	if spec.Init != nil {
+		t.Init = spec.Init.Duration
+	}
+	if spec.Plan != nil {
+		t.Plan = spec.Plan.Duration
+	}
+	if spec.Apply != nil {
+		t.Apply = spec.Apply.Duration
+	}
+	if spec.Destroy != nil {
+		t.Destroy = spec.Destroy.Duration
+	}
+	return t
+}
+
+// run returns the longest duration of the commands of a run of executionType, zero when one of them is unlimited
+func (t Timeouts) run(executionType TerraformExecutionType) time.Duration {
+	timeouts := []time.Duration{t.Init, t.Plan, t.Apply}
+	if executionType == TerraformDestroy {
+		timeouts = []time.Duration{t.Init, t.Destroy}
+	}
+	var total time.Duration
+	for _, timeout := range timeouts {
+		if timeout == 0 {
+			return 0
+		}
+		total += timeout
+	}
+	return total
+}
+
+// TimeoutError is the error of a terraform command stopped as it ran longer than its timeout
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+	// Err is the error of the stopped command
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("terraform %s did not complete within %s", e.Command, e.Timeout)
+}
+
+// Unwrap returns the error of the stopped command
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// CanceledError is the error of a terraform command stopped as its run was canceled
+type CanceledError struct {
+	Command string
+	// Err is the error of the stopped command, nil when it was canceled before it started
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("terraform %s was canceled", e.Command)
+}
+
+// Unwrap returns the error of the stopped command
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// isTimeout returns whether err is the error of a terraform command which ran longer than its timeout
+func isTimeout(err error) bool {
+	var timeout *TimeoutError
+	return errors.As(err, &timeout)
+}
+
+// isCanceled returns whether err is the error of a canceled terraform command
+func isCanceled(err error) bool {
+	var canceled *CanceledError
+	return errors.As(err, &canceled)
+}
+
+// runCanceler is implemented by the Executors whose runs go on between the reconciles, like the Jobs
+type runCanceler interface {
+	// CancelRun stops the run in progress of the Configuration namespace/name, it returns false when there is none
+	CancelRun(ctx context.Context, namespace, name string) (bool, error)
+}
+
+// terraformRun is a reconcile of a Configuration, which can be canceled
+type terraformRun struct {
+	once     sync.Once
+	canceled chan struct{}
+}
+
+// cancel stops the command in progress of the run, and the next ones
+func (run *terraformRun) cancel() {
+	run.once.Do(func() { close(run.canceled) })
+}
+
+// done returns a channel closed once the run is canceled, or nil for no run
+func (run *terraformRun) done() <-chan struct{} {
+	if run == nil {
+		return nil
+	}
+	return run.canceled
+}
+
+// runRegistry holds the reconciles in progress, by the key of their Configuration
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*terraformRun
+}
+
+// start registers the reconcile of key, until finish is called
+func (r *runRegistry) start(key string) *terraformRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs == nil {
+		r.runs = map[string]*terraformRun{}
+	}
+	run := &terraformRun{canceled: make(chan struct{})}
+	r.runs[key] = run
+	return run
+}
+
+// finish unregisters the reconcile of key
+func (r *runRegistry) finish(key string, run *terraformRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs[key] == run {
+		delete(r.runs, key)
+	}
+}
+
+// cancel cancels the reconcile of key, it returns false when there is none
+func (r *runRegistry) cancel(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runs[key]
+	if ok {
+		run.cancel()
+	}
+	return ok
+}
+
+// CancelRun stops the terraform run in progress of the Configuration namespace/name. Terraform is interrupted, then
+// killed once the kill grace period is over. It returns false when no run is in progress.
+func (r *ConfigurationReconciler) CancelRun(namespace, name string) (bool, error) {
+	canceled := r.runs.cancel(namespace + "/" + name)
+	if canceler, ok := r.executor().(runCanceler); ok {
+		found, err := canceler.CancelRun(context.Background(), namespace, name)
+		if err != nil {
+			return false, err
+		}
+		canceled = canceled || found
+	}
+	if canceled {
+		klog.InfoS("Canceled the terraform run", "Namespace", namespace, "Name", name)
+	}
+	return canceled, nil
+}
+
+// signalFunc sends sig to the terraform of command of a run, and to its plugins, and to no other process
+type signalFunc func(command string, sig syscall.Signal) error
+
+// runCommand runs the terraform command with f until it returns. When the command runs longer than timeout, or the
+// run of meta is canceled, terraform is interrupted with signal so that it persists its state and releases its lock,
+// then killed, with signal and by canceling the context of f, once the kill grace period is over.
+func (meta *TFConfigurationMeta) runCommand(ctx context.Context, command string, timeout time.Duration, signal signalFunc, f func(ctx context.Context) error) error {
+	select {
+	case <-meta.run.done():
+		return &CanceledError{Command: command}
+	default:
+	}
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- f(runCtx) }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var stopped error
+	var result string
+	select {
+	case err := <-done:
+		metrics.ObserveTerraform(command, start, err)
+		return err
+	case <-expired:
+		stopped, result = &TimeoutError{Command: command, Timeout: timeout}, metrics.ResultTimeout
+	case <-meta.run.done():
+		stopped, result = &CanceledError{Command: command}, metrics.ResultCanceled
+	}
+
+	klog.InfoS("Interrupting terraform", "Reason", stopped.Error(), "Namespace", meta.Namespace, "Name", meta.Name)
+	if err := signal(command, syscall.SIGINT); err != nil {
+		klog.ErrorS(err, "Failed to interrupt terraform")
+	}
+	grace := time.NewTimer(meta.KillGracePeriod)
+	defer grace.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-grace.C:
+		klog.InfoS("Killing terraform, which did not stop within the grace period", "GracePeriod", meta.KillGracePeriod,
+			"Namespace", meta.Namespace, "Name", meta.Name)
+		if killErr := signal(command, syscall.SIGKILL); killErr != nil {
+			klog.ErrorS(killErr, "Failed to kill terraform")
+		}
+		kill()
+		err = <-done
+	}
+	// The command completed meanwhile
+	if err == nil {
+		metrics.ObserveTerraform(command, start, nil)
+		return nil
+	}
+	metrics.ObserveTerraformResult(command, start, result)
+	switch stopped := stopped.(type) {
+	case *TimeoutError:
+		stopped.Err = err
+	case *CanceledError:
+		stopped.Err = err
+	}
+	return stopped
+}
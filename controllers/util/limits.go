@@ -0,0 +1,52 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// limitedCgroupEnv is the cgroup the wrapper joins
+	limitedCgroupEnv = "TERRAFORM_CONTROLLER_LIMITED_CGROUP"
+	// limitedRlimitDataEnv is the limit of the data segment the wrapper sets, in bytes
+	limitedRlimitDataEnv = "TERRAFORM_CONTROLLER_LIMITED_RLIMIT_DATA"
+)
+
+// ResourceLimits are the resources of the processes of a terraform run, unlimited when zero
+type ResourceLimits struct {
+	CPULimitMilli      int64
+	MemoryLimitBytes   int64
+	CPURequestMilli    int64
+	MemoryRequestBytes int64
+}
+
+// IsZero returns whether no resource is set
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// ResourceLimitExceededError is the error of a terraform run stopped as it exceeded a limit of its resources
+type ResourceLimitExceededError struct {
+	// Resource is the resource whose limit was exceeded, like memory
+	Resource string
+	// Reason tells how the limit was exceeded, rather than the whole output of terraform
+	Reason string
+	// Err is the error of the run
+	Err error
+}
+
+func (e *ResourceLimitExceededError) Error() string {
+	return fmt.Sprintf("terraform exceeded its %s limit: %s", e.Resource, e.Reason)
+}
+
+// Unwrap returns the error of the run
+func (e *ResourceLimitExceededError) Unwrap() error {
+	return e.Err
+}
+
+// IsResourceLimitExceeded returns whether err is the error of a run which exceeded a limit of its resources
+func IsResourceLimitExceeded(err error) bool {
+	var exceeded *ResourceLimitExceededError
+	return errors.As(err, &exceeded)
+}
@@ -0,0 +1,257 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// cpuPeriod is the period of the CPU quota of the cgroups, in microseconds
+	cpuPeriod = 100000
+)
+
+var (
+	cgroupOnce   sync.Once
+	cgroupParent string
+	cgroupErr    error
+)
+
+// LimitedRun confines the processes of a terraform run to its resources: in a cgroup with cgroup v2, otherwise with
+// the rlimits of terraform and of each of its plugins.
+type LimitedRun struct {
+	limits ResourceLimits
+	cgroup string
+}
+
+// StartLimitedRun prepares the confinement of the run name, until Close is called
+func StartLimitedRun(name string, limits ResourceLimits) (*LimitedRun, error) {
+	run := &LimitedRun{limits: limits}
+	parent, err := cgroupV2Parent()
+	if err != nil {
+		klog.V(2).InfoS("Limiting terraform with rlimits, as cgroup v2 is not available", "Reason", err.Error())
+		return run, nil
+	}
+	run.cgroup = filepath.Join(parent, "terraform-"+name)
+	// A cgroup left by a controller which was killed is reused
+	if err := os.Mkdir(run.cgroup, 0755); err != nil && !os.IsExist(err) {
+		return nil, errors.Wrap(err, "failed to create the cgroup of terraform")
+	}
+	settings := map[string]string{
+		"memory.max":      "max",
+		"memory.swap.max": "0",
+		"memory.low":      "0",
+		"cpu.max":         "max",
+		"cpu.weight":      "100",
+	}
+	if limits.MemoryLimitBytes > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.MemoryLimitBytes, 10)
+	}
+	if limits.MemoryRequestBytes > 0 {
+		settings["memory.low"] = strconv.FormatInt(limits.MemoryRequestBytes, 10)
+	}
+	if limits.CPULimitMilli > 0 {
+		quota := limits.CPULimitMilli * cpuPeriod / 1000
+		if quota < 1000 {
+			quota = 1000
+		}
+		settings["cpu.max"] = fmt.Sprintf("%d %d", quota, cpuPeriod)
+	}
+	if limits.CPURequestMilli > 0 {
+		// The weight of the CPU shares of a request, as the kubelet computes them
+		shares := limits.CPURequestMilli * 1024 / 1000
+		if shares < 2 {
+			shares = 2
+		}
+		settings["cpu.weight"] = strconv.FormatInt(1+((shares-2)*9999)/262142, 10)
+	}
+	for file, value := range settings {
+		err := ioutil.WriteFile(filepath.Join(run.cgroup, file), []byte(value), 0644)
+		// There is no swap accounting without swap
+		if err != nil && !(file == "memory.swap.max" && os.IsNotExist(err)) {
+			run.Close()
+			return nil, errors.Wrapf(err, "failed to set %s of the cgroup of terraform", file)
+		}
+	}
+	return run, nil
+}
+
+// env returns the env of the wrapper confining terraform to the limits of the run, which is empty without run
+func (r *LimitedRun) env() map[string]string {
+	env := map[string]string{}
+	if r == nil {
+		return env
+	}
+	switch {
+	case r.cgroup != "":
+		env[limitedCgroupEnv] = r.cgroup
+	case r.limits.MemoryLimitBytes > 0:
+		env[limitedRlimitDataEnv] = strconv.FormatInt(r.limits.MemoryLimitBytes, 10)
+	}
+	if r.cgroup == "" && r.limits.CPULimitMilli > 0 {
+		klog.V(2).InfoS("The CPU limit of terraform is only enforced with cgroup v2")
+	}
+	return env
+}
+
+// Exceeded returns a ResourceLimitExceededError when the run failed with err as it exceeded a limit, err otherwise
+func (r *LimitedRun) Exceeded(err error) error {
+	if err == nil {
+		return nil
+	}
+	if r.cgroup != "" {
+		if kills := r.oomKills(); kills > 0 {
+			reason := fmt.Sprintf("%d processes were killed as they reached %d bytes", kills, r.limits.MemoryLimitBytes)
+			return &ResourceLimitExceededError{Resource: "memory", Reason: reason, Err: err}
+		}
+		return err
+	}
+	if r.limits.MemoryLimitBytes == 0 {
+		return err
+	}
+	// The Go runtime of terraform and of the plugins fails to allocate beyond the rlimit
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if strings.Contains(line, "out of memory") || strings.Contains(line, "cannot allocate memory") {
+			reason := fmt.Sprintf("%s, with a limit of %d bytes", strings.TrimSpace(line), r.limits.MemoryLimitBytes)
+			return &ResourceLimitExceededError{Resource: "memory", Reason: reason, Err: err}
+		}
+	}
+	return err
+}
+
+// Close kills the processes left in the cgroup of the run, and removes it
+func (r *LimitedRun) Close() {
+	if r.cgroup == "" {
+		return
+	}
+	// cgroup.kill is only there from Linux 5.14
+	_ = ioutil.WriteFile(filepath.Join(r.cgroup, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(r.cgroup); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	klog.ErrorS(err, "Failed to remove the cgroup of terraform", "Cgroup", r.cgroup)
+}
+
+// oomKills returns the number of processes of the cgroup killed as it reached its memory limit
+func (r *LimitedRun) oomKills() int {
+	data, err := ioutil.ReadFile(filepath.Join(r.cgroup, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.Atoi(fields[1])
+			return count
+		}
+	}
+	return 0
+}
+
+// cgroupV2Parent returns the cgroup of the controller in which the cgroups of terraform are created. As a cgroup
+// with processes can not delegate controllers to its children, the controller moves into the child cgroup
+// controller first.
+func cgroupV2Parent() (string, error) {
+	cgroupOnce.Do(func() {
+		controllers, err := ioutil.ReadFile(filepath.Join(cgroupRoot, "cgroup.controllers"))
+		if err != nil {
+			cgroupErr = errors.New("the cgroup v2 hierarchy is not mounted on " + cgroupRoot)
+			return
+		}
+		if !hasFields(string(controllers), "cpu", "memory") {
+			cgroupErr = errors.New("the cpu and memory controllers are not available in cgroup v2")
+			return
+		}
+		self, err := ioutil.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			cgroupErr = errors.Wrap(err, "failed to read the cgroup of the controller")
+			return
+		}
+		var own string
+		for _, line := range strings.Split(strings.TrimSpace(string(self)), "\n") {
+			if strings.HasPrefix(line, "0::") {
+				own = strings.TrimPrefix(line, "0::")
+			}
+		}
+		if own == "" {
+			cgroupErr = errors.New("the controller is not in a cgroup v2")
+			return
+		}
+		parent := filepath.Join(cgroupRoot, own)
+		subtree, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+		if err != nil {
+			cgroupErr = errors.Wrap(err, "failed to read the controllers of the cgroup of the controller")
+			return
+		}
+		if !hasFields(string(subtree), "cpu", "memory") {
+			leaf := filepath.Join(parent, "controller")
+			if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+				cgroupErr = errors.Wrap(err, "failed to create the cgroup of the controller")
+				return
+			}
+			if err := ioutil.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+				cgroupErr = errors.Wrap(err, "failed to move the controller into its cgroup")
+				return
+			}
+			if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644); err != nil {
+				cgroupErr = errors.Wrap(err, "failed to delegate the cpu and memory controllers")
+				return
+			}
+		}
+		cgroupParent = parent
+		klog.InfoS("Limiting terraform with cgroup v2", "Cgroup", parent)
+	})
+	return cgroupParent, cgroupErr
+}
+
+// hasFields returns whether the whitespace separated fields of s contain all of fields
+func hasFields(s string, fields ...string) bool {
+	present := map[string]bool{}
+	for _, f := range strings.Fields(s) {
+		present[f] = true
+	}
+	for _, f := range fields {
+		if !present[f] {
+			return false
+		}
+	}
+	return true
+}
+
+// confine confines the wrapper to the limits of its env
+func confine() {
+	if cgroup := os.Getenv(limitedCgroupEnv); cgroup != "" {
+		// 0 is the writing process
+		if err := ioutil.WriteFile(filepath.Join(cgroup, "cgroup.procs"), []byte("0"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to join the cgroup of terraform: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if data := os.Getenv(limitedRlimitDataEnv); data != "" {
+		limit, err := strconv.ParseUint(data, 10, 64)
+		if err == nil {
+			err = syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: limit, Max: limit})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to limit the memory of terraform: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
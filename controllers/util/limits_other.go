@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import "k8s.io/klog/v2"
+
+// LimitedRun does not confine the processes of a terraform run, the limits are only enforced on Linux
+type LimitedRun struct{}
+
+// StartLimitedRun returns a LimitedRun which does not enforce limits
+func StartLimitedRun(name string, limits ResourceLimits) (*LimitedRun, error) {
+	klog.V(2).InfoS("The limits of terraform are only enforced on Linux")
+	return &LimitedRun{}, nil
+}
+
+// env returns no env, as the wrapper does not confine terraform
+func (r *LimitedRun) env() map[string]string {
+	return nil
+}
+
+// Exceeded returns err
+func (r *LimitedRun) Exceeded(err error) error {
+	return err
+}
+
+// Close does nothing
+func (r *LimitedRun) Close() {}
+
+// confine does nothing
+func confine() {}
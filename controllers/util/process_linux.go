@@ -0,0 +1,81 @@
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// InterruptChildProcesses sends SIGINT to the child processes of the controller, like terraform, and to their
+// process groups, as terraform-exec starts terraform in its own process group. It returns the number of processes
+// interrupted.
+func InterruptChildProcesses() (int, error) {
+	return signalChildProcesses(syscall.SIGINT, false, nil)
+}
+
+// KillChildProcessGroups sends SIGKILL to the process groups led by the child processes of the controller, like
+// terraform and its plugins. The child processes in the process group of the controller, like the CLI of a container
+// runtime, are left. It returns the number of process groups killed.
+func KillChildProcessGroups() (int, error) {
+	return signalChildProcesses(syscall.SIGKILL, true, nil)
+}
+
+// SignalRun sends sig to the process groups led by the child processes of the controller which run the terraform of
+// the run name, wrapped by WrapTerraform, and to its plugins. The processes of the other runs are left. It returns
+// the number of process groups signaled.
+func SignalRun(name string, sig syscall.Signal) (int, error) {
+	marker := []byte("\x00" + runNameEnv + "=" + name + "\x00")
+	return signalChildProcesses(sig, true, func(pid string) bool {
+		environ, err := ioutil.ReadFile("/proc/" + pid + "/environ")
+		return err == nil && bytes.Contains(append([]byte{0}, environ...), marker)
+	})
+}
+
+// signalChildProcesses sends sig to the child processes of the controller and to the process groups they lead, or
+// only to those process groups, of the processes matching match unless it is nil
+func signalChildProcesses(sig syscall.Signal, groupsOnly bool, match func(pid string) bool) (int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list the processes")
+	}
+	self := os.Getpid()
+	signaled := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		stat, err := ioutil.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			// The process exited meanwhile
+			continue
+		}
+		// The command is in parentheses and may contain spaces, the parent pid is the second field after it
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err != nil || ppid != self {
+			continue
+		}
+		if match != nil && !match(entry.Name()) {
+			continue
+		}
+		if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+			err = syscall.Kill(-pid, sig)
+		} else if groupsOnly {
+			continue
+		} else {
+			err = syscall.Kill(pid, sig)
+		}
+		if err == nil {
+			signaled++
+		}
+	}
+	return signaled, nil
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startRun starts a process of the run name, wrapped like terraform in its own process group, and returns the
+// channel of its exit
+func startRun(t *testing.T, name string) <-chan error {
+	t.Helper()
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not installed")
+	}
+	wrapper, env, err := WrapTerraform(name, sleepPath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(wrapper, "60")
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+	return exited
+}
+
+func TestSignalRun(t *testing.T) {
+	run := startRun(t, "default/c1")
+	other := startRun(t, "default/c2")
+	// The wrapper runs sleep in its place
+	time.Sleep(200 * time.Millisecond)
+
+	count, err := SignalRun("default/c1", syscall.SIGKILL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected the process group of the run to be signaled, got %d", count)
+	}
+	select {
+	case err := <-run:
+		if err == nil {
+			t.Error("expected the process of the run to be killed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the process of the run to be killed")
+	}
+	select {
+	case <-other:
+		t.Error("expected the process of the other run to be left")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
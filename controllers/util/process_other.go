@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// InterruptChildProcesses is only supported on Linux
+func InterruptChildProcesses() (int, error) {
+	return 0, errors.New("interrupting the child processes is only supported on Linux")
+}
+
+// KillChildProcessGroups is only supported on Linux
+func KillChildProcessGroups() (int, error) {
+	return 0, errors.New("killing the child processes is only supported on Linux")
+}
+
+// SignalRun is only supported on Linux
+func SignalRun(name string, sig syscall.Signal) (int, error) {
+	return 0, errors.New("signaling the processes of a run is only supported on Linux")
+}
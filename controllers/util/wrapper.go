@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// wrappedExecPathEnv is the terraform executable run by the controller executable as a wrapper
+	wrappedExecPathEnv = "TERRAFORM_CONTROLLER_EXEC_PATH"
+	// wrappedVarEnvPrefix replaces the prefix TF_VAR_ of the variables of terraform in the env of the wrapper, as
+	// terraform-exec refuses to set them
+	wrappedVarEnvPrefix   = "TERRAFORM_CONTROLLER_VAR_"
+	terraformVarEnvPrefix = "TF_VAR_"
+	// runNameEnv marks the processes of a run, for SignalRun to only signal them. It is left in the env of terraform.
+	runNameEnv = "TERRAFORM_CONTROLLER_RUN"
+)
+
+// WrapTerraform returns the executable to run in place of the terraform of execPath, the controller executable, and
+// the env to set on terraform-exec for the wrapper to run terraform of the run name with the env of the controller
+// and env, confined to the limits of run unless it is nil. The env of a run is never set on the controller, so it
+// only reaches the terraform of the run.
+func WrapTerraform(name, execPath string, env map[string]string, run *LimitedRun) (string, map[string]string, error) {
+	wrapper, err := os.Executable()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to find the controller executable wrapping terraform")
+	}
+	merged := map[string]string{}
+	for _, kv := range os.Environ() {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			merged[parts[0]] = parts[1]
+		}
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	wrapperEnv := make(map[string]string, len(merged)+4)
+	for k, v := range merged {
+		if strings.HasPrefix(k, terraformVarEnvPrefix) {
+			k = wrappedVarEnvPrefix + strings.TrimPrefix(k, terraformVarEnvPrefix)
+		}
+		wrapperEnv[k] = v
+	}
+	wrapperEnv[wrappedExecPathEnv] = execPath
+	wrapperEnv[runNameEnv] = name
+	for k, v := range run.env() {
+		wrapperEnv[k] = v
+	}
+	return wrapper, wrapperEnv, nil
+}
+
+// IsWrappedExec returns whether the controller executable was run to wrap terraform
+func IsWrappedExec() bool {
+	return os.Getenv(wrappedExecPathEnv) != ""
+}
+
+// WrappedExec confines the process to the limits of its env, then runs terraform in its place, with the variables of
+// terraform renamed back
+func WrappedExec() {
+	execPath := os.Getenv(wrappedExecPathEnv)
+	confine()
+	var env []string
+	for _, kv := range os.Environ() {
+		switch {
+		case strings.HasPrefix(kv, wrappedVarEnvPrefix):
+			env = append(env, terraformVarEnvPrefix+strings.TrimPrefix(kv, wrappedVarEnvPrefix))
+		case strings.HasPrefix(kv, wrappedExecPathEnv+"="), strings.HasPrefix(kv, limitedCgroupEnv+"="),
+			strings.HasPrefix(kv, limitedRlimitDataEnv+"="):
+		default:
+			env = append(env, kv)
+		}
+	}
+	err := syscall.Exec(execPath, append([]string{execPath}, os.Args[1:]...), env)
+	fmt.Fprintf(os.Stderr, "failed to run terraform: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the test executable as the wrapper of terraform, like the controller executable
+func TestMain(m *testing.M) {
+	if IsWrappedExec() {
+		WrappedExec()
+	}
+	os.Exit(m.Run())
+}
+
+func TestWrapTerraform(t *testing.T) {
+	envPath, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("env is not installed")
+	}
+	t.Setenv("TF_VAR_controller", "controller")
+
+	wrapper, wrapperEnv, err := WrapTerraform("default/c1", envPath, map[string]string{
+		"TF_VAR_name":       "c1",
+		"AWS_ACCESS_KEY_ID": "AKIARUN",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv("AWS_ACCESS_KEY_ID"); ok {
+		t.Error("expected the env of the run not to be set on the controller")
+	}
+	for k := range wrapperEnv {
+		if strings.HasPrefix(k, "TF_VAR_") {
+			t.Errorf("expected the variables to be renamed for terraform-exec, got %s", k)
+		}
+	}
+
+	cmd := exec.Command(wrapper)
+	for k, v := range wrapperEnv {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]bool{}
+	for _, kv := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		env[kv] = true
+	}
+	for _, kv := range []string{"TF_VAR_name=c1", "AWS_ACCESS_KEY_ID=AKIARUN", "TF_VAR_controller=controller",
+		"TERRAFORM_CONTROLLER_RUN=default/c1"} {
+		if !env[kv] {
+			t.Errorf("expected %s in the env of terraform, got %v", kv, env)
+		}
+	}
+	for kv := range env {
+		if strings.HasPrefix(kv, "TERRAFORM_CONTROLLER_") && !strings.HasPrefix(kv, "TERRAFORM_CONTROLLER_RUN=") {
+			t.Errorf("expected the env of the wrapper to be removed, got %s", kv)
+		}
+	}
+}
@@ -1,29 +1,217 @@
 package main
 
 import (
+	"flag"
 	"os"
+	"os/exec"
+	"strings"
+	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	"github.com/ttsubo2000/terraform-controller/controllers"
+	providercred "github.com/ttsubo2000/terraform-controller/controllers/provider"
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
 	"github.com/ttsubo2000/terraform-controller/manager"
+	"github.com/ttsubo2000/terraform-controller/metrics"
 	"github.com/ttsubo2000/terraform-controller/rest"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/tools/record"
 	"github.com/ttsubo2000/terraform-controller/types"
 )
 
 func main() {
-	clientState := cacheObj.NewStore(cacheObj.MetaNamespaceKeyFunc)
+	// The controller executable wraps terraform to run it with the env of its run, confined to its resources
+	if util.IsWrappedExec() {
+		util.WrappedExec()
+	}
+
+	var restOptions rest.Options
+	var authorizationPolicyFile string
+	var providerRevalidationInterval time.Duration
+	var encryptionKeyFile string
+	var metricsBindAddress string
+	var eventTTL time.Duration
+	var managerOptions manager.Options
+	var storeBackend string
+	var executorType string
+	var terraformImage string
+	var timeouts controllers.Timeouts
+	var killGracePeriod time.Duration
+	var credentialsEnvAllowlist string
+	jobExecutor := controllers.NewJobExecutor(nil)
+	containerExecutor := controllers.NewContainerExecutor()
+	flag.StringVar(&restOptions.BindAddress, "bind-address", ":10000", "The address the REST API listens on.")
+	flag.StringVar(&restOptions.TLSCertFile, "tls-cert-file", "", "File containing the x509 certificate for serving the REST API over HTTPS.")
+	flag.StringVar(&restOptions.TLSPrivateKeyFile, "tls-private-key-file", "", "File containing the x509 private key matching --tls-cert-file.")
+	flag.StringVar(&restOptions.ClientCAFile, "client-ca-file", "", "Enables client certificate authentication with the CAs of this file.")
+	flag.StringVar(&restOptions.TokenAuthFile, "token-auth-file", "", "Enables bearer token authentication with the static tokens of this file.")
+	flag.DurationVar(&restOptions.ReadTimeout, "read-timeout", 30*time.Second, "The maximum duration for reading a REST API request, zero means no timeout.")
+	flag.DurationVar(&restOptions.WriteTimeout, "write-timeout", 30*time.Second, "The maximum duration for writing a REST API response, zero means no timeout.")
+	flag.DurationVar(&restOptions.IdleTimeout, "idle-timeout", 120*time.Second, "The maximum duration a keep-alive connection to the REST API is kept idle, zero means no timeout.")
+	flag.StringVar(&authorizationPolicyFile, "authorization-policy-file", "", "File containing the ClusterRoles, Roles and their bindings which authorize the REST API requests.")
+	flag.DurationVar(&providerRevalidationInterval, "provider-revalidation-interval", 10*time.Minute, "How often the credentials of the Providers are validated again, zero means never.")
+	flag.StringVar(&storeBackend, "store", "memory", "The backend of the store of the objects: memory, or kubernetes for the API server of --kubeconfig or of the cluster the controller runs in.")
+	flag.StringVar(&executorType, "executor", "local", "How terraform is run: local, container for the containers of --container-runtime, or job for the Jobs of the cluster of --kubeconfig or of the cluster the controller runs in.")
+	flag.StringVar(&terraformImage, "terraform-image", controllers.DefaultTerraformImage, "The image running terraform in the containers of the container executor and the Jobs of the job executor.")
+	flag.StringVar(&jobExecutor.BusyboxImage, "busybox-image", controllers.DefaultBusyboxImage, "The image copying the configuration into the working volume of the Jobs of the job executor.")
+	flag.StringVar(&jobExecutor.GitImage, "git-image", controllers.DefaultGitImage, "The image cloning the remote configurations in the Jobs of the job executor.")
+	flag.StringVar(&containerExecutor.Runtime, "container-runtime", controllers.DefaultContainerRuntime, "The CLI of the container runtime of the container executor, like docker or podman.")
+	flag.StringVar(&containerExecutor.Network, "container-network", "", "The network of the containers of the container executor, the default one of the runtime when it is empty.")
+	flag.StringVar(&containerExecutor.WorkspaceDir, "container-workspace-dir", controllers.DefaultContainerWorkspaceDir, "The directory of the workspaces of the Configurations of the container executor.")
+	flag.DurationVar(&timeouts.Init, "init-timeout", controllers.DefaultInitTimeout, "How long terraform init runs before it is interrupted, for the Configurations which do not set spec.timeouts.init. Zero means no timeout.")
+	flag.DurationVar(&timeouts.Plan, "plan-timeout", controllers.DefaultPlanTimeout, "How long terraform plan runs before it is interrupted, for the Configurations which do not set spec.timeouts.plan. Zero means no timeout.")
+	flag.DurationVar(&timeouts.Apply, "apply-timeout", controllers.DefaultApplyTimeout, "How long terraform apply runs before it is interrupted, for the Configurations which do not set spec.timeouts.apply. Zero means no timeout.")
+	flag.DurationVar(&timeouts.Destroy, "destroy-timeout", controllers.DefaultDestroyTimeout, "How long terraform destroy runs before it is interrupted, for the Configurations which do not set spec.timeouts.destroy. Zero means no timeout.")
+	flag.DurationVar(&killGracePeriod, "kill-grace-period", controllers.DefaultKillGracePeriod, "How long an interrupted terraform has to persist its state and release its lock before it is killed.")
+	flag.StringVar(&providercred.CredentialsDir, "credentials-dir", "", "The directory of the credentials files of the Providers with the Filesystem source, which is disabled when it is empty.")
+	flag.StringVar(&credentialsEnvAllowlist, "credentials-env-allowlist", "", "Comma separated environment variables the Providers with the Environment source can read, or their prefixes ending with *. The source is disabled when it is empty.")
+	flag.StringVar(&encryptionKeyFile, "encryption-key-file", "", "File containing the AES keys which encrypt the data of the Secrets in the store, the first one encrypts.")
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8080", "The address the metrics endpoint listens on, 0 disables it.")
+	flag.DurationVar(&eventTTL, "event-ttl", record.DefaultTTL, "How long the Events are kept after their last occurrence.")
+	flag.DurationVar(&managerOptions.GracefulShutdownTimeout, "graceful-shutdown-timeout", manager.DefaultGracefulShutdownTimeout, "How long the terraform runs in progress have to stop once the controller is stopped, before it exits anyway.")
+	flag.BoolVar(&managerOptions.LeaderElection, "leader-elect", false, "Run the controllers only on the instance holding the lease, so that the other instances are on standby. It requires --store=kubernetes, so that the instances share the objects.")
+	flag.StringVar(&managerOptions.LeaderElectionLockFile, "leader-election-lock-file", "", "File holding the lease of the leader election, shared by the instances. The kubernetes store holds it in a Lease when it is empty.")
+	flag.StringVar(&managerOptions.LeaderElectionNamespace, "leader-election-namespace", "vela-system", "Namespace of the Lease of the leader election with the kubernetes store.")
+	flag.StringVar(&managerOptions.LeaderElectionID, "leader-election-id", "terraform-controller", "Name of the Lease of the leader election with the kubernetes store.")
+	flag.StringVar(&managerOptions.LeaderElectionIdentity, "leader-election-identity", "", "Identity of the instance in the lease, its hostname and a random suffix by default.")
+	flag.DurationVar(&managerOptions.LeaseDuration, "leader-election-lease-duration", manager.DefaultLeaseDuration, "How long a standby waits before taking over a lease which is not renewed.")
+	flag.DurationVar(&managerOptions.RenewDeadline, "leader-election-renew-deadline", manager.DefaultRenewDeadline, "How long the leader tries to renew its lease before it stops leading.")
+	flag.DurationVar(&managerOptions.RetryPeriod, "leader-election-retry-period", manager.DefaultRetryPeriod, "How often the lease is renewed, or tried to be acquired by a standby.")
+	flag.Parse()
+	for _, name := range strings.Split(credentialsEnvAllowlist, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			providercred.CredentialsEnvAllowlist = append(providercred.CredentialsEnvAllowlist, name)
+		}
+	}
+
+	// A standby taking over must find the objects and the states the former leader stored
+	if managerOptions.LeaderElection && storeBackend != "kubernetes" {
+		klog.Error("--leader-elect requires --store=kubernetes, as the instances do not share the objects of the memory store")
+		os.Exit(1)
+	}
 
-	go func() {
-		rest.HandleRequests(clientState)
-	}()
+	var clientState cacheObj.Store
+	var kubeStore *cacheObj.KubeStore
+	switch {
+	case storeBackend == "kubernetes":
+		if encryptionKeyFile != "" {
+			klog.Error("the Secrets of the kubernetes store are encrypted by the encryption at rest of the API server, not --encryption-key-file")
+			os.Exit(1)
+		}
+		config, err := ctrlconfig.GetConfig()
+		if err != nil {
+			klog.Error(err, "problem kubeconfig")
+			os.Exit(1)
+		}
+		kubeStore, err = cacheObj.NewKubeStore(config)
+		if err != nil {
+			klog.Error(err, "problem kubernetes store")
+			os.Exit(1)
+		}
+		klog.InfoS("Storing the objects in the Kubernetes API server", "Host", config.Host)
+		clientState = kubeStore
+		managerOptions.LeaderElectionConfig = config
+	case storeBackend != "memory":
+		klog.Errorf("unknown store %q, must be memory or kubernetes", storeBackend)
+		os.Exit(1)
+	case encryptionKeyFile != "":
+		encryption, err := cacheObj.NewSecretEncryption(encryptionKeyFile)
+		if err != nil {
+			klog.Error(err, "problem encryption key file")
+			os.Exit(1)
+		}
+		klog.InfoS("Encrypting the Secrets", "Encryption", encryption.String())
+		clientState = cacheObj.NewEncryptedStore(cacheObj.MetaNamespaceKeyFunc, encryption)
+	default:
+		clientState = cacheObj.NewStore(cacheObj.MetaNamespaceKeyFunc)
+	}
+	var executor controllers.Executor
+	switch executorType {
+	case "local":
+		executor = &controllers.LocalExecutor{}
+	case "container":
+		if _, err := exec.LookPath(containerExecutor.Runtime); err != nil {
+			klog.Error(err, "problem container runtime")
+			os.Exit(1)
+		}
+		klog.InfoS("Running terraform in containers", "Runtime", containerExecutor.Runtime, "Image", terraformImage)
+		containerExecutor.Image = terraformImage
+		executor = containerExecutor
+	case "job":
+		config, err := ctrlconfig.GetConfig()
+		if err != nil {
+			klog.Error(err, "problem kubeconfig")
+			os.Exit(1)
+		}
+		jobExecutor.Client, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			klog.Error(err, "problem job executor")
+			os.Exit(1)
+		}
+		klog.InfoS("Running terraform in the Jobs of the Kubernetes cluster", "Host", config.Host)
+		jobExecutor.TerraformImage = terraformImage
+		executor = jobExecutor
+	default:
+		klog.Errorf("unknown executor %q, must be local, container or job", executorType)
+		os.Exit(1)
+	}
+	if authorizationPolicyFile != "" {
+		if err := rest.LoadAuthorizationPolicy(authorizationPolicyFile, clientState); err != nil {
+			klog.Error(err, "problem authorization policy")
+			os.Exit(1)
+		}
+	}
 
-	mgr := manager.NewManager()
-	mgr.Add(controllers.NewController("provider", &controllers.ProviderReconciler{Client: clientState}, &types.Provider{}, clientState))
-	mgr.Add(controllers.NewController("configuration", &controllers.ConfigurationReconciler{Client: clientState}, &types.Configuration{}, clientState))
+	server, err := rest.NewServer(clientState, restOptions)
+	if err != nil {
+		klog.Error(err, "problem REST server")
+		os.Exit(1)
+	}
+
+	mgr, err := manager.NewManager(managerOptions)
+	if err != nil {
+		klog.Error(err, "problem manager")
+		os.Exit(1)
+	}
+
+	recorder := record.NewRecorder(clientState, "terraform-controller", eventTTL)
+	providerController := controllers.NewController("provider", &controllers.ProviderReconciler{Client: clientState, Recorder: recorder, RevalidationInterval: providerRevalidationInterval}, &types.Provider{}, clientState)
+	configurationReconciler := &controllers.ConfigurationReconciler{Client: clientState, Recorder: recorder, Executor: executor, Timeouts: timeouts, KillGracePeriod: killGracePeriod}
+	configurationController := controllers.NewController("configuration", configurationReconciler, &types.Configuration{}, clientState)
+	server.SetRunCanceler(configurationReconciler)
+	server.AddReadyzCheck("provider-controller", whenElected(mgr, providerController.Ready))
+	server.AddReadyzCheck("configuration-controller", whenElected(mgr, configurationController.Ready))
+
+	if kubeStore != nil {
+		mgr.Add(kubeStore)
+	}
+	mgr.Add(server)
+	mgr.Add(recorder)
+	if metricsBindAddress != "0" {
+		metrics.RegisterConfigurationStates(clientState)
+		mgr.Add(metrics.NewServer(metricsBindAddress))
+	}
+	mgr.Add(&providercred.CredentialsFileWatcher{Client: clientState})
+	mgr.Add(providerController)
+	mgr.Add(configurationController)
 	if err := mgr.Start(manager.SetupSignalHandler()); err != nil {
 		klog.Error(err, "problem controller")
 		os.Exit(1)
 	}
 }
+
+// whenElected only runs check once the manager is elected, so that the standby instances are ready to serve the
+// REST API
+func whenElected(mgr manager.Manager, check rest.Checker) rest.Checker {
+	return func() error {
+		select {
+		case <-mgr.Elected():
+			return check()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	tfcleaderelection "github.com/ttsubo2000/terraform-controller/tools/leaderelection"
+)
+
+// Defaults of the leader election, as in controller-runtime
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// newLeaderElector returns the LeaderElector of the lease of opts, which starts and stops the leader Runnables of cm
+func newLeaderElector(opts Options, cm *controllerManager) (*leaderelection.LeaderElector, error) {
+	identity := opts.LeaderElectionIdentity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the hostname for the leader election identity")
+		}
+		identity = hostname + "_" + string(uuid.NewUUID())
+	}
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = DefaultLeaseDuration
+	}
+	if opts.RenewDeadline <= 0 {
+		opts.RenewDeadline = DefaultRenewDeadline
+	}
+	if opts.RetryPeriod <= 0 {
+		opts.RetryPeriod = DefaultRetryPeriod
+	}
+
+	lock, err := newResourceLock(opts, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	leaderElector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   opts.LeaseDuration,
+		RenewDeadline:   opts.RenewDeadline,
+		RetryPeriod:     opts.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lock.Describe(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) { cm.onStartedLeading() },
+			OnStoppedLeading: func() { cm.onStoppedLeading() },
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid leader election")
+	}
+	return leaderElector, nil
+}
+
+// newResourceLock returns the lock file of opts, or else its Lease object in the Kubernetes API server
+func newResourceLock(opts Options, identity string) (resourcelock.Interface, error) {
+	if opts.LeaderElectionLockFile != "" {
+		return tfcleaderelection.NewFileLock(opts.LeaderElectionLockFile, identity), nil
+	}
+	if opts.LeaderElectionConfig == nil {
+		return nil, errors.New("the leader election requires a lock file, or the Kubernetes store for a Lease")
+	}
+	if opts.LeaderElectionNamespace == "" || opts.LeaderElectionID == "" {
+		return nil, errors.New("the leader election with a Lease requires its namespace and name")
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(opts.LeaderElectionConfig, "leader-election"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the client of the Lease")
+	}
+	return resourcelock.New(resourcelock.LeasesResourceLock, opts.LeaderElectionNamespace, opts.LeaderElectionID,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+}
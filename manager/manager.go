@@ -2,16 +2,27 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/ttsubo2000/terraform-controller/controllers/util"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/klog/v2"
-
-	"github.com/ttsubo2000/terraform-controller/controllers"
 )
 
+// DefaultGracefulShutdownTimeout is how long the Runnables have to stop by default, like a terraform apply in
+// progress which is interrupted
+const DefaultGracefulShutdownTimeout = 5 * time.Minute
+
+// errLeaderElectionLost is returned by Start once the lease could not be renewed
+var errLeaderElectionLost = errors.New("leader election lost")
+
 //var onlyOneSignalHandler = make(chan struct{})
 var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 
@@ -21,52 +32,203 @@ var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 func SetupSignalHandler() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	c := make(chan os.Signal, 1)
+	c := make(chan os.Signal, 2)
 	signal.Notify(c, shutdownSignals...)
 	go func() {
 		<-c
 		klog.Info("Stopping controller")
 		cancel()
-		time.Sleep(1 * time.Second)
-		os.Exit(1) // Exit directly.
+		<-c
+		os.Exit(1) // second signal. Exit directly.
 	}()
 
 	return ctx
 }
 
+// Runnable is started by the Manager. Start blocks until ctx is done, or until the Runnable fails.
+type Runnable interface {
+	Start(ctx context.Context) error
+}
+
+// LeaderElectionRunnable is implemented by the Runnables which tell whether they only run on the leader.
+// The Runnables which do not implement it only run on the leader, like the controllers.
+type LeaderElectionRunnable interface {
+	NeedLeaderElection() bool
+}
+
 // A Manager is required to create Controllers.
 type Manager interface {
-	Add(c *controllers.Controller) error
+	Add(r Runnable) error
 	Start(ctx context.Context) error
+
+	// Elected is closed once the Manager is elected leader, or once it starts without leader election
+	Elected() <-chan struct{}
+}
+
+// Options configures a Manager
+type Options struct {
+	// GracefulShutdownTimeout is how long the Runnables have to stop once the Manager is stopped,
+	// DefaultGracefulShutdownTimeout when zero. Start returns an error when they do not stop in time.
+	GracefulShutdownTimeout time.Duration
+
+	// LeaderElection runs the Runnables which need leader election only on the instance holding the lease
+	LeaderElection bool
+	// LeaderElectionLockFile is the file holding the lease, shared by the instances
+	LeaderElectionLockFile string
+	// LeaderElectionConfig is the config of the Kubernetes API server holding the lease in a Lease object, when
+	// there is no LeaderElectionLockFile
+	LeaderElectionConfig *rest.Config
+	// LeaderElectionNamespace and LeaderElectionID are the namespace and name of the Lease object
+	LeaderElectionNamespace string
+	LeaderElectionID        string
+	// LeaderElectionIdentity identifies the instance in the lease, its hostname and a random suffix when empty
+	LeaderElectionIdentity string
+	// LeaseDuration is how long a standby waits before taking over a lease which is not renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader tries to renew its lease before it stops leading
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the lease is renewed, or acquired by a standby
+	RetryPeriod time.Duration
 }
 
 type controllerManager struct {
-	runnables []*controllers.Controller
+	runnables               []Runnable
+	leaderRunnables         []Runnable
+	gracefulShutdownTimeout time.Duration
+
+	leaderElector *leaderelection.LeaderElector
+	// onStartedLeading and onStoppedLeading are called by leaderElector
+	onStartedLeading func()
+	onStoppedLeading func()
+	elected          chan struct{}
 }
 
-// Add sets dependencies on i, and adds it to the list of Runnables to start.
-func (cm *controllerManager) Add(c *controllers.Controller) error {
-	cm.runnables = append(cm.runnables, c)
+// Add adds r to the list of Runnables to start.
+func (cm *controllerManager) Add(r Runnable) error {
+	if l, ok := r.(LeaderElectionRunnable); ok && !l.NeedLeaderElection() {
+		cm.runnables = append(cm.runnables, r)
+		return nil
+	}
+	cm.leaderRunnables = append(cm.leaderRunnables, r)
 	return nil
 }
 
+// Elected is closed once the Manager is elected leader
+func (cm *controllerManager) Elected() <-chan struct{} {
+	return cm.elected
+}
+
+// Start starts every Runnable, those which need leader election once the Manager is elected, and blocks until ctx
+// is done, one of them fails or the leadership is lost. The remaining Runnables are then stopped, and Start returns
+// once all of them have returned, or with an error once the graceful shutdown timeout is over. The lease is released
+// only once the Runnables have returned, so that a standby does not take over while terraform still runs. Once the
+// leadership is lost, a standby may already have taken over, so terraform is killed and Start returns at once.
 func (cm *controllerManager) Start(ctx context.Context) error {
-	errChan := make(chan error)
-	for _, c := range cm.runnables {
-		go c.Run(ctx, errChan)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stopping := false
+	errChan := make(chan error, len(cm.runnables)+len(cm.leaderRunnables)+1)
+	start := func(runnables []Runnable) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopping {
+			return
+		}
+		for _, r := range runnables {
+			wg.Add(1)
+			go func(r Runnable) {
+				defer wg.Done()
+				if err := r.Start(runCtx); err != nil {
+					errChan <- err
+				}
+			}(r)
+		}
+	}
+	start(cm.runnables)
+
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
+	electionDone := make(chan struct{})
+	if cm.leaderElector == nil {
+		close(cm.elected)
+		start(cm.leaderRunnables)
+		close(electionDone)
+	} else {
+		cm.onStartedLeading = func() {
+			klog.InfoS("Elected leader, starting the controllers", "Identity", cm.leaderElector.GetLeader())
+			close(cm.elected)
+			start(cm.leaderRunnables)
+		}
+		cm.onStoppedLeading = func() {
+			if electionCtx.Err() != nil {
+				return
+			}
+			// The lease expires on the standby soon after the leader failed to renew it, so terraform is not given
+			// the graceful shutdown timeout to persist its state
+			count, err := util.KillChildProcessGroups()
+			if err != nil {
+				klog.ErrorS(err, "Failed to kill terraform")
+			} else {
+				klog.InfoS("Leader election lost, killed terraform", "ProcessGroups", count)
+			}
+			errChan <- errLeaderElectionLost
+		}
+		go func() {
+			defer close(electionDone)
+			cm.leaderElector.Run(electionCtx)
+		}()
 	}
 
+	var err error
 	select {
 	case <-ctx.Done():
 		// We are done
-		return nil
-	case err := <-errChan:
-		// Error starting or running a runnable
+	case err = <-errChan:
+		// Error starting or running a runnable, or leader election lost
+	}
+	cancel()
+	mu.Lock()
+	stopping = true
+	mu.Unlock()
+	if err == errLeaderElectionLost {
+		// The Runnables must not write the store anymore, which the new leader writes
 		return err
 	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	klog.InfoS("Waiting for the runnables to stop", "GracePeriod", cm.gracefulShutdownTimeout)
+	select {
+	case <-stopped:
+	case <-time.After(cm.gracefulShutdownTimeout):
+		return fmt.Errorf("the runnables did not stop within the graceful shutdown timeout of %s", cm.gracefulShutdownTimeout)
+	}
+	stopElection()
+	<-electionDone
+	return err
 }
 
 // New returns a new Manager for creating Controllers.
-func NewManager() Manager {
-	return &controllerManager{}
+func NewManager(opts Options) (Manager, error) {
+	if opts.GracefulShutdownTimeout <= 0 {
+		opts.GracefulShutdownTimeout = DefaultGracefulShutdownTimeout
+	}
+	cm := &controllerManager{
+		gracefulShutdownTimeout: opts.GracefulShutdownTimeout,
+		elected:                 make(chan struct{}),
+	}
+	if opts.LeaderElection {
+		leaderElector, err := newLeaderElector(opts, cm)
+		if err != nil {
+			return nil, err
+		}
+		cm.leaderElector = leaderElector
+	}
+	return cm, nil
 }
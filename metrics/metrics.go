@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// Registry is the registry of the metrics served on /metrics
+var Registry = prometheus.NewRegistry()
+
+// Results of the reconciles, and of the terraform runs
+const (
+	ResultSuccess      = "success"
+	ResultError        = "error"
+	ResultRequeue      = "requeue"
+	ResultRequeueAfter = "requeue_after"
+	ResultTimeout      = "timeout"
+	ResultCanceled     = "canceled"
+)
+
+var (
+	// ReconcileTotal counts the reconciles per controller and result
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "controller_runtime_reconcile_total",
+		Help: "Total number of reconciliations per controller",
+	}, []string{"controller", "result"})
+
+	// ReconcileErrors counts the reconciles which returned an error per controller
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "controller_runtime_reconcile_errors_total",
+		Help: "Total number of reconciliation errors per controller",
+	}, []string{"controller"})
+
+	// ReconcileTime is the duration of the reconciles per controller
+	ReconcileTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "controller_runtime_reconcile_time_seconds",
+		Help:    "Length of time per reconciliation per controller",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0, 1.25, 1.5, 1.75, 2.0, 2.5, 3.0, 3.5, 4.0, 4.5, 5, 6, 7, 8, 9, 10, 15, 20, 25, 30, 40, 50, 60},
+	}, []string{"controller"})
+
+	// TerraformDuration is the duration of the terraform commands, like init or apply, per command and result
+	TerraformDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "terraform_controller_terraform_duration_seconds",
+		Help:    "Duration of the terraform commands run for the Configurations",
+		Buckets: []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"command", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ReconcileTotal,
+		ReconcileErrors,
+		ReconcileTime,
+		TerraformDuration,
+	)
+}
+
+// ObserveTerraform records the duration of a terraform command since start, with the result of err
+func ObserveTerraform(command string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	ObserveTerraformResult(command, start, result)
+}
+
+// ObserveTerraformResult records the duration of a terraform command since start, with result
+func ObserveTerraformResult(command string, start time.Time, result string) {
+	TerraformDuration.WithLabelValues(command, result).Observe(time.Since(start).Seconds())
+}
+
+// configurationStates are the states of the Configurations which are always reported, even without Configuration
+var configurationStates = []types.ConfigurationState{
+	types.Authorizing,
+	types.ProviderNotFound,
+	types.ProviderNotReady,
+	types.ConfigurationStaticCheckFailed,
+	types.Available,
+	types.ConfigurationProvisioningAndChecking,
+	types.ConfigurationDestroying,
+	types.ConfigurationApplyFailed,
+	types.ConfigurationResourceLimitExceeded,
+	types.ConfigurationTimeout,
+	types.ConfigurationCanceled,
+	types.ConfigurationDestroyFailed,
+	types.ConfigurationReloading,
+	types.GeneratingOutputs,
+	types.InvalidRegion,
+	types.TerraformInitError,
+}
+
+// configurationStateCollector counts the Configurations of the store per state when it is collected
+type configurationStateCollector struct {
+	client cacheObj.Store
+	desc   *prometheus.Desc
+}
+
+// RegisterConfigurationStates registers the gauge of the Configurations of client per state
+func RegisterConfigurationStates(client cacheObj.Store) {
+	Registry.MustRegister(&configurationStateCollector{
+		client: client,
+		desc: prometheus.NewDesc("terraform_controller_configurations",
+			"Number of Configurations per state", []string{"state"}, nil),
+	})
+}
+
+func (c *configurationStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *configurationStateCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[types.ConfigurationState]int, len(configurationStates))
+	for _, state := range configurationStates {
+		counts[state] = 0
+	}
+	for _, obj := range c.client.List() {
+		if configuration, ok := obj.(*types.Configuration); ok {
+			counts[configuration.Status.Apply.State]++
+		}
+	}
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), string(state))
+	}
+}
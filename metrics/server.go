@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// shutdownTimeout is how long the metrics server waits for the scrapes in flight when it stops
+const shutdownTimeout = 5 * time.Second
+
+// Server serves the metrics of Registry on /metrics in the Prometheus text format
+type Server struct {
+	bindAddress string
+	server      *http.Server
+}
+
+// NewServer returns the metrics server listening on bindAddress
+func NewServer(bindAddress string) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError}))
+	return &Server{
+		bindAddress: bindAddress,
+		server: &http.Server{
+			Handler:     mux,
+			ReadTimeout: 30 * time.Second,
+			IdleTimeout: 120 * time.Second,
+		},
+	}
+}
+
+// NeedLeaderElection returns false, as the metrics are served by the standby instances as well
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the metrics until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.bindAddress)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", s.bindAddress)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		klog.InfoS("Serving the metrics", "Address", listener.Addr().String())
+		serveErr <- s.server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	klog.Info("Stopping the metrics server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "failed to stop the metrics server gracefully")
+	}
+	return nil
+}
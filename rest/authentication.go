@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UserInfo describes the authenticated caller of a request
+type UserInfo struct {
+	Name   string
+	Groups []string
+}
+
+// Authenticator authenticates the caller of a request
+type Authenticator interface {
+	// AuthenticateRequest returns the caller of the request. ok is false when the request does not carry
+	// credentials this Authenticator understands, err is set when the credentials are present but invalid.
+	AuthenticateRequest(r *http.Request) (user *UserInfo, ok bool, err error)
+}
+
+// unionAuthenticator tries each Authenticator in turn, and returns the first caller which could be authenticated
+type unionAuthenticator []Authenticator
+
+func (authenticators unionAuthenticator) AuthenticateRequest(r *http.Request) (*UserInfo, bool, error) {
+	var errs []string
+	for _, authenticator := range authenticators {
+		user, ok, err := authenticator.AuthenticateRequest(r)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if ok {
+			return user, true, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, false, errors.New(strings.Join(errs, ", "))
+	}
+	return nil, false, nil
+}
+
+// tokenEntry is a static bearer token and the user it authenticates
+type tokenEntry struct {
+	token string
+	user  *UserInfo
+}
+
+// TokenFileAuthenticator authenticates bearer tokens listed in a static token file
+type TokenFileAuthenticator struct {
+	tokens []tokenEntry
+}
+
+// NewTokenFileAuthenticator reads a token file in the format of the Kubernetes API server, a CSV file with at
+// least three columns: token, user name, user uid, followed by an optional column of comma separated group names
+func NewTokenFileAuthenticator(path string) (*TokenFileAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the token file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	authenticator := &TokenFileAuthenticator{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the token file")
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("token file %s, line %d: at least 3 columns are required, found %d", path, line, len(record))
+		}
+		token := strings.TrimSpace(record[0])
+		if token == "" {
+			return nil, fmt.Errorf("token file %s, line %d: the token is empty", path, line)
+		}
+		user := &UserInfo{Name: strings.TrimSpace(record[1])}
+		if len(record) >= 4 {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					user.Groups = append(user.Groups, group)
+				}
+			}
+		}
+		authenticator.tokens = append(authenticator.tokens, tokenEntry{token: token, user: user})
+	}
+	return authenticator, nil
+}
+
+// AuthenticateRequest authenticates the bearer token of the Authorization header
+func (a *TokenFileAuthenticator) AuthenticateRequest(r *http.Request) (*UserInfo, bool, error) {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if auth == "" {
+		return nil, false, nil
+	}
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "bearer") {
+		return nil, false, nil
+	}
+	token := strings.TrimSpace(parts[1])
+	for _, entry := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(entry.token), []byte(token)) == 1 {
+			return entry.user, true, nil
+		}
+	}
+	return nil, false, errors.New("invalid bearer token")
+}
+
+// X509Authenticator authenticates the client certificate presented in the TLS handshake.
+// The certificate has already been verified against the client CA by the TLS server, the common name is used
+// as the user name and the organizations as the groups.
+type X509Authenticator struct{}
+
+// AuthenticateRequest authenticates the verified client certificate of the request
+func (X509Authenticator) AuthenticateRequest(r *http.Request) (*UserInfo, bool, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, false, nil
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, false, errors.New("the client certificate is not signed by a trusted CA")
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, false, errors.New("the client certificate has no common name")
+	}
+	return &UserInfo{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, true, nil
+}
@@ -0,0 +1,276 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+)
+
+// SystemMastersGroup is the group whose members are allowed every request, like in Kubernetes
+const SystemMastersGroup = "system:masters"
+
+// Attributes describes a request to be authorized
+type Attributes struct {
+	User *UserInfo
+	Verb string
+	// APIGroup, Resource, Namespace and Name are set for requests to objects of the store
+	APIGroup  string
+	Resource  string
+	Namespace string
+	Name      string
+	// ResourceRequest is false for requests to other paths, which are matched against the nonResourceURLs of rules
+	ResourceRequest bool
+	Path            string
+}
+
+// Authorizer decides whether a request is allowed
+type Authorizer interface {
+	Authorize(attrs Attributes) (allowed bool, reason string, err error)
+}
+
+// RBACAuthorizer evaluates the ClusterRoles and Roles in the store, bound to the caller by ClusterRoleBindings and
+// RoleBindings in the store. A ClusterRoleBinding grants its role in every namespace, a RoleBinding only in its own.
+type RBACAuthorizer struct {
+	Client cacheObj.Store
+}
+
+// Authorize allows the request when a rule bound to the caller or one of its groups matches it
+func (a *RBACAuthorizer) Authorize(attrs Attributes) (bool, string, error) {
+	if attrs.User == nil {
+		return false, "the request is not authenticated", nil
+	}
+	for _, group := range attrs.User.Groups {
+		if group == SystemMastersGroup {
+			return true, "", nil
+		}
+	}
+
+	for _, obj := range a.Client.List() {
+		var (
+			subjects  []rbacv1.Subject
+			roleRef   rbacv1.RoleRef
+			namespace string
+		)
+		switch binding := obj.(type) {
+		case *rbacv1.ClusterRoleBinding:
+			subjects, roleRef = binding.Subjects, binding.RoleRef
+		case *rbacv1.RoleBinding:
+			if binding.Namespace != attrs.Namespace || !attrs.ResourceRequest {
+				continue
+			}
+			subjects, roleRef, namespace = binding.Subjects, binding.RoleRef, binding.Namespace
+		default:
+			continue
+		}
+		if !appliesTo(subjects, attrs.User) {
+			continue
+		}
+		for _, rule := range a.rules(roleRef, namespace) {
+			if ruleAllows(rule, attrs) {
+				return true, "", nil
+			}
+		}
+	}
+	return false, fmt.Sprintf("no rule bound to user %q allows it", attrs.User.Name), nil
+}
+
+// rules returns the rules of the role referenced by a binding
+func (a *RBACAuthorizer) rules(roleRef rbacv1.RoleRef, namespace string) []rbacv1.PolicyRule {
+	for _, obj := range a.Client.List() {
+		switch role := obj.(type) {
+		case *rbacv1.ClusterRole:
+			// ClusterRoles are cluster scoped, the namespace the controller stores them in is ignored
+			if roleRef.Kind == "ClusterRole" && role.Name == roleRef.Name {
+				return role.Rules
+			}
+		case *rbacv1.Role:
+			if roleRef.Kind == "Role" && role.Name == roleRef.Name && role.Namespace == namespace {
+				return role.Rules
+			}
+		}
+	}
+	return nil
+}
+
+func appliesTo(subjects []rbacv1.Subject, user *UserInfo) bool {
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.UserKind:
+			if subject.Name == user.Name {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range user.Groups {
+				if subject.Name == group {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func ruleAllows(rule rbacv1.PolicyRule, attrs Attributes) bool {
+	if !matches(rule.Verbs, attrs.Verb) {
+		return false
+	}
+	if !attrs.ResourceRequest {
+		for _, url := range rule.NonResourceURLs {
+			if url == rbacv1.NonResourceAll || url == attrs.Path ||
+				(strings.HasSuffix(url, "*") && strings.HasPrefix(attrs.Path, strings.TrimSuffix(url, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(rule.APIGroups, attrs.APIGroup) &&
+		matches(rule.Resources, attrs.Resource) &&
+		(len(rule.ResourceNames) == 0 || matches(rule.ResourceNames, attrs.Name))
+}
+
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAuthorizationPolicy stores the ClusterRoles, ClusterRoleBindings, Roles and RoleBindings of a multi-document
+// YAML file, so that the RBACAuthorizer can evaluate them
+func LoadAuthorizationPolicy(path string, clientState cacheObj.Store) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the authorization policy file")
+	}
+	defer file.Close()
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the authorization policy file")
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		data, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert the authorization policy to JSON")
+		}
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(data, &typeMeta); err != nil {
+			return errors.Wrap(err, "failed to decode the authorization policy")
+		}
+		var obj interface{}
+		switch typeMeta.Kind {
+		case "ClusterRole":
+			obj = &rbacv1.ClusterRole{}
+		case "ClusterRoleBinding":
+			obj = &rbacv1.ClusterRoleBinding{}
+		case "Role":
+			obj = &rbacv1.Role{}
+		case "RoleBinding":
+			obj = &rbacv1.RoleBinding{}
+		default:
+			return fmt.Errorf("the kind %q is not supported in the authorization policy", typeMeta.Kind)
+		}
+		if err := json.Unmarshal(data, obj); err != nil {
+			return errors.Wrapf(err, "failed to decode the %s", typeMeta.Kind)
+		}
+		if err := clientState.Add(obj); err != nil {
+			return err
+		}
+		klog.InfoS("Loaded authorization policy", "Kind", typeMeta.Kind, "Name", obj.(metav1.Object).GetName())
+	}
+}
+
+type authContextKey struct{}
+
+// requestAuth authenticates and authorizes the requests to the REST API
+type requestAuth struct {
+	authenticator Authenticator
+	authorizer    Authorizer
+}
+
+// authContext is stored in the context of an authenticated request, so that handlers can authorize further actions
+type authContext struct {
+	auth *requestAuth
+	user *UserInfo
+}
+
+// authorized wraps a handler with the authentication of the caller and the authorization of verb on resource for
+// the namespace and name of the path. An empty verb only authenticates the caller, the handler authorizes itself
+// with authorizeRequest. A nil requestAuth allows every request.
+func (a *requestAuth) authorized(verb string, resource schema.GroupResource, handler http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok, err := a.authenticator.AuthenticateRequest(r)
+		if err != nil || !ok {
+			if err != nil {
+				klog.InfoS("Failed to authenticate the request", "Path", r.URL.Path, "Error", err)
+			}
+			writeError(w, apierrors.NewUnauthorized("Unauthorized"))
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, &authContext{auth: a, user: user}))
+		if verb != "" {
+			vars := mux.Vars(r)
+			if err := authorizeRequest(r, verb, resource, vars["namespace"], vars["name"]); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// authorizeRequest checks that the caller of the request is allowed verb on the object. It allows everything when
+// authentication is not configured.
+func authorizeRequest(r *http.Request, verb string, resource schema.GroupResource, namespace, name string) error {
+	ac, ok := r.Context().Value(authContextKey{}).(*authContext)
+	if !ok {
+		return nil
+	}
+	allowed, reason, err := ac.auth.authorizer.Authorize(Attributes{
+		User:            ac.user,
+		Verb:            verb,
+		APIGroup:        resource.Group,
+		Resource:        resource.Resource,
+		Namespace:       namespace,
+		Name:            name,
+		ResourceRequest: true,
+		Path:            r.URL.Path,
+	})
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if !allowed {
+		return apierrors.NewForbidden(resource, name, fmt.Errorf("user %q cannot %s %s in the namespace %q: %s",
+			ac.user.Name, verb, resource.String(), namespace, reason))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+)
+
+const (
+	applyActionCreated    = "created"
+	applyActionConfigured = "configured"
+	applyActionFailed     = "failed"
+)
+
+// applyKind describes how objects of one kind are applied from a manifest
+type applyKind struct {
+	// order is the position of the kind when applying, so that referenced objects exist before their referrers
+	order     int
+	resource  schema.GroupResource
+	newObject func() metav1.Object
+	validate  func(obj metav1.Object) error
+	// reconcile tells whether an update triggers the reconciliation of the object
+	reconcile bool
+}
+
+var applyKinds = map[string]applyKind{
+	"Secret": {
+		resource:  secretResource,
+		order:     0,
+		newObject: func() metav1.Object { return &types.Secret{} },
+		validate:  func(obj metav1.Object) error { return validateSecret(obj.(*types.Secret)) },
+		reconcile: false,
+	},
+	"Provider": {
+		resource:  providerResource,
+		order:     1,
+		newObject: func() metav1.Object { return &types.Provider{} },
+		validate:  func(obj metav1.Object) error { return validateProvider(obj.(*types.Provider)) },
+		reconcile: true,
+	},
+	"Configuration": {
+		resource:  configurationResource,
+		order:     2,
+		newObject: func() metav1.Object { return &types.Configuration{} },
+		validate:  func(obj metav1.Object) error { return validateConfiguration(obj.(*types.Configuration)) },
+		reconcile: true,
+	},
+}
+
+// ApplyResult is the outcome of applying one object of a manifest
+type ApplyResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Action is one of created, configured or failed
+	Action string         `json:"action"`
+	Error  *metav1.Status `json:"error,omitempty"`
+}
+
+// ApplyResponse is the response of the apply endpoint, with one result per object in the order they were applied
+type ApplyResponse struct {
+	Results []ApplyResult `json:"results"`
+}
+
+// manifestObject is one document of a manifest
+type manifestObject struct {
+	kind string
+	obj  metav1.Object
+	err  error
+}
+
+func applyManifest(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+	klog.Info("Endpoint Hit: applyManifest")
+	if _, err := isYAMLRequest(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	objects, err := splitManifest(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return applyKinds[objects[i].kind].order < applyKinds[objects[j].kind].order
+	})
+
+	response := ApplyResponse{Results: []ApplyResult{}}
+	for _, o := range objects {
+		result := ApplyResult{Kind: o.kind}
+		if o.obj != nil {
+			result.Namespace = o.obj.GetNamespace()
+			result.Name = o.obj.GetName()
+		}
+		err := o.err
+		if err == nil {
+			result.Action, err = applyObject(r, clientState, o.kind, o.obj)
+		}
+		if err != nil {
+			result.Action = applyActionFailed
+			status := statusOf(err)
+			result.Error = &status
+		}
+		response.Results = append(response.Results, result)
+	}
+	writeObject(w, http.StatusOK, response)
+}
+
+// splitManifest decodes every document of a multi-document YAML (or JSON) stream.
+// A document which can not be decoded is returned with its error, so that the rest of the manifest is still applied.
+func splitManifest(body io.Reader) ([]manifestObject, error) {
+	var objects []manifestObject
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(body))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to read the manifest: %v", err))
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		objects = append(objects, decodeManifestDocument(doc))
+	}
+}
+
+func decodeManifestDocument(doc []byte) manifestObject {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return manifestObject{err: apierrors.NewBadRequest(fmt.Sprintf("the document is not valid YAML: %v", err))}
+	}
+	if string(data) == "null" {
+		// a document which only holds comments
+		return manifestObject{err: apierrors.NewBadRequest("the document is empty")}
+	}
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return manifestObject{err: apierrors.NewBadRequest(fmt.Sprintf("the document is not an object: %v", err))}
+	}
+	kind, ok := applyKinds[typeMeta.Kind]
+	if !ok {
+		return manifestObject{kind: typeMeta.Kind, err: apierrors.NewBadRequest(fmt.Sprintf("the kind %q is not supported, only Secret, Provider and Configuration can be applied", typeMeta.Kind))}
+	}
+	obj := kind.newObject()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return manifestObject{kind: typeMeta.Kind, err: apierrors.NewBadRequest(fmt.Sprintf("failed to decode the %s: %v", typeMeta.Kind, err))}
+	}
+	return manifestObject{kind: typeMeta.Kind, obj: obj}
+}
+
+// applyObject creates the object, or updates it when it already exists, if the caller is allowed to
+func applyObject(r *http.Request, clientState cacheObj.Store, kind string, obj metav1.Object) (string, error) {
+	info := applyKinds[kind]
+	if err := info.validate(obj); err != nil {
+		return "", err
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	key := fmt.Sprintf("%s/%s/%s", kind, obj.GetNamespace(), obj.GetName())
+	if current, exists, _ := clientState.GetByKey(key); exists {
+		if err := authorizeRequest(r, "update", info.resource, obj.GetNamespace(), obj.GetName()); err != nil {
+			return "", err
+		}
+		if err := checkResourceVersion(info.resource, obj.GetName(), obj.GetResourceVersion(), current.(metav1.Object).GetResourceVersion()); err != nil {
+			return "", err
+		}
+		if err := clientState.Update(obj, info.reconcile); err != nil {
+			return "", err
+		}
+		return applyActionConfigured, nil
+	}
+	if err := authorizeRequest(r, "create", info.resource, obj.GetNamespace(), obj.GetName()); err != nil {
+		return "", err
+	}
+	if err := clientState.Add(obj); err != nil {
+		return "", err
+	}
+	return applyActionCreated, nil
+}
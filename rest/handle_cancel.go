@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// RunCanceler cancels the terraform run in progress of a Configuration
+type RunCanceler interface {
+	// CancelRun returns false when no run of the Configuration namespace/name is in progress
+	CancelRun(namespace, name string) (bool, error)
+}
+
+// runCanceler holds the RunCanceler of the cancel endpoint, set once the controller is created
+type runCanceler struct {
+	mu       sync.RWMutex
+	canceler RunCanceler
+}
+
+// SetRunCanceler sets what cancels the terraform runs of the Configurations for the cancel endpoint
+func (s *Server) SetRunCanceler(canceler RunCanceler) {
+	s.runCanceler.mu.Lock()
+	defer s.runCanceler.mu.Unlock()
+	s.runCanceler.canceler = canceler
+}
+
+// cancelConfigurationRun cancels the terraform run in progress of a Configuration. Terraform is interrupted, and
+// killed when it does not stop within the kill grace period of the controller.
+func (c *runCanceler) cancelConfigurationRun(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+	klog.Info("Endpoint Hit: cancelConfigurationRun")
+	vars := mux.Vars(r)
+	name := vars["name"]
+	namespace := vars["namespace"]
+
+	if _, exists, err := clientState.GetByKey(fmt.Sprintf("Configuration/%s/%s", namespace, name)); err != nil || !exists {
+		writeError(w, apierrors.NewNotFound(configurationResource, name))
+		return
+	}
+	c.mu.RLock()
+	canceler := c.canceler
+	c.mu.RUnlock()
+	if canceler == nil {
+		writeError(w, apierrors.NewServiceUnavailable("the controller of the Configurations is not running"))
+		return
+	}
+	canceled, err := canceler.CancelRun(namespace, name)
+	if err != nil {
+		writeError(w, apierrors.NewInternalError(err))
+		return
+	}
+	if !canceled {
+		// The runs are only in progress on the leader
+		writeError(w, apierrors.NewConflict(configurationResource, name, errors.New("no terraform run is in progress on this instance")))
+		return
+	}
+	writeObject(w, http.StatusAccepted, metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusSuccess,
+		Code:     http.StatusAccepted,
+		Message:  fmt.Sprintf("the terraform run of the Configuration %s/%s is canceled", namespace, name),
+	})
+}
@@ -3,12 +3,12 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
 	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog/v2"
 )
 
@@ -33,8 +33,7 @@ func returnSingleConfiguration(w http.ResponseWriter, r *http.Request, clientSta
 	namespace := vars["namespace"]
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Configuration/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Configuration Not Found\n")
+		writeError(w, apierrors.NewNotFound(configurationResource, name))
 		return
 	}
 	configuration := obj.(*types.Configuration)
@@ -43,12 +42,32 @@ func returnSingleConfiguration(w http.ResponseWriter, r *http.Request, clientSta
 
 func createNewConfiguration(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
 	klog.Info("Endpoint Hit: createNewConfiguration")
-	reqBody, _ := ioutil.ReadAll(r.Body)
 	var configuration types.Configuration
-	json.Unmarshal(reqBody, &configuration)
-	clientState.Add(&configuration)
+	if err := decodeRequestBody(r, &configuration, "Configuration"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorizeRequest(r, "create", configurationResource, configuration.Namespace, configuration.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateConfiguration(&configuration); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	json.NewEncoder(w).Encode(configuration)
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	key := fmt.Sprintf("Configuration/%s/%s", configuration.Namespace, configuration.Name)
+	if _, exists, _ := clientState.GetByKey(key); exists {
+		writeError(w, apierrors.NewAlreadyExists(configurationResource, configuration.Name))
+		return
+	}
+	if err := clientState.Add(&configuration); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusCreated, configuration)
 }
 
 func updateConfiguration(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -56,23 +75,36 @@ func updateConfiguration(w http.ResponseWriter, r *http.Request, clientState cac
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
-	_, _, err := clientState.GetByKey(fmt.Sprintf("Configuration/%s/%s", namespace, name))
+	var configuration types.Configuration
+	if err := decodeRequestBody(r, &configuration, "Configuration"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if name != configuration.ObjectMeta.Name || namespace != configuration.ObjectMeta.Namespace {
+		writeError(w, apierrors.NewBadRequest("the name and namespace of the request body do not match the request URL"))
+		return
+	}
+	if err := validateConfiguration(&configuration); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	obj, _, err := clientState.GetByKey(fmt.Sprintf("Configuration/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Configuration Not Found\n")
+		writeError(w, apierrors.NewNotFound(configurationResource, name))
 		return
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		var configuration types.Configuration
-		json.Unmarshal(reqBody, &configuration)
-		if name == configuration.ObjectMeta.Name && namespace == configuration.ObjectMeta.Namespace {
-			clientState.Update(&configuration, true)
-			json.NewEncoder(w).Encode(configuration)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, "Invalid Request Body\n")
-		}
 	}
+	if err := checkResourceVersion(configurationResource, name, configuration.ResourceVersion, obj.(*types.Configuration).ResourceVersion); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := clientState.Update(&configuration, true); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusOK, configuration)
 }
 
 func deleteConfiguration(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -80,13 +112,16 @@ func deleteConfiguration(w http.ResponseWriter, r *http.Request, clientState cac
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Configuration/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Configuration Not Found\n")
+		writeError(w, apierrors.NewNotFound(configurationResource, name))
 		return
-	} else {
-		configuration := obj.(*types.Configuration)
-		clientState.Delete(configuration)
+	}
+	configuration := obj.(*types.Configuration)
+	if err := clientState.Delete(configuration); err != nil {
+		writeError(w, err)
 	}
 }
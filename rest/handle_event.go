@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// returnEvents returns the Events, of the object kind/namespace/name of the query parameter involvedObject when it
+// is set, from the oldest to the latest occurrence. Listing the Events of an object is authorized in its namespace.
+func returnEvents(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+	klog.Info("Endpoint Hit: returnEvents")
+	var kind, namespace, name string
+	involvedObject := r.URL.Query().Get("involvedObject")
+	if involvedObject != "" {
+		parts := strings.Split(involvedObject, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			writeError(w, apierrors.NewBadRequest(fmt.Sprintf("the query parameter involvedObject %q is not kind/namespace/name", involvedObject)))
+			return
+		}
+		kind, namespace, name = parts[0], parts[1], parts[2]
+	}
+	if err := authorizeRequest(r, "list", eventResource, namespace, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	EventsList := []*types.Event{}
+	for _, obj := range clientState.List() {
+		event, ok := obj.(*types.Event)
+		if !ok {
+			continue
+		}
+		if involvedObject != "" && (event.InvolvedObject.Kind != kind || event.InvolvedObject.Namespace != namespace ||
+			event.InvolvedObject.Name != name) {
+			continue
+		}
+		EventsList = append(EventsList, event)
+	}
+	sort.Slice(EventsList, func(i, j int) bool {
+		return EventsList[i].LastTimestamp.Before(&EventsList[j].LastTimestamp)
+	})
+	json.NewEncoder(w).Encode(EventsList)
+}
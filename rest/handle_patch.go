@@ -0,0 +1,168 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+	"github.com/gorilla/mux"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+	// applyPatchType is accepted for clients which speak server-side apply. The YAML document is applied as a
+	// JSON merge patch, field ownership is not tracked.
+	applyPatchType = "application/apply-patch+yaml"
+)
+
+// newUnprocessablePatch returns an error indicating the patch could not be applied to the current object
+func newUnprocessablePatch(err error) *apierrors.StatusError {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusUnprocessableEntity,
+		Reason:  metav1.StatusReasonInvalid,
+		Message: fmt.Sprintf("the patch could not be applied: %v", err),
+	}}
+}
+
+// applyPatch applies the patch of the request to the JSON document of the current object
+func applyPatch(r *http.Request, current []byte) ([]byte, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	switch mediaType {
+	case mergePatchType, jsonPatchType, applyPatchType:
+	default:
+		return nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status: metav1.StatusFailure,
+			Code:   http.StatusUnsupportedMediaType,
+			Reason: metav1.StatusReasonUnsupportedMediaType,
+			Message: fmt.Sprintf("the patch was in an unknown format %q, only %s, %s and %s are supported",
+				contentType, mergePatchType, jsonPatchType, applyPatchType),
+		}}
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to read the request body: %v", err))
+	}
+	switch mediaType {
+	case jsonPatchType:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("the request body is not a valid JSON patch: %v", err))
+		}
+		patched, err := operations.Apply(current)
+		if err != nil {
+			return nil, newUnprocessablePatch(err)
+		}
+		return patched, nil
+	case applyPatchType:
+		if patch, err = yaml.YAMLToJSON(patch); err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("the request body is not valid YAML: %v", err))
+		}
+	}
+	patched, err := jsonpatch.MergePatch(current, patch)
+	if err != nil {
+		if err == jsonpatch.ErrBadJSONPatch || err == jsonpatch.ErrBadJSONDoc {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("the request body is not a valid merge patch: %v", err))
+		}
+		return nil, newUnprocessablePatch(err)
+	}
+	return patched, nil
+}
+
+// patchObject patches a Secret, Provider or Configuration. The patched object goes through the same validation and
+// resource version check as a full update.
+func patchObject(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store, kind string) {
+	klog.Infof("Endpoint Hit: patch%s", kind)
+	vars := mux.Vars(r)
+	name := vars["name"]
+	namespace := vars["namespace"]
+	info := applyKinds[kind]
+
+	// The data of a Secret is only returned, or read by the operations of a JSON patch like test or copy, for
+	// callers allowed to get it
+	includeData := true
+	if kind == "Secret" {
+		var err error
+		if includeData, err = includeSecretData(r, namespace, name); err != nil {
+			writeError(w, err)
+			return
+		}
+		if isJSONPatchRequest(r) {
+			if err := authorizeRequest(r, "get", secretDataResource, namespace, name); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	obj, _, err := clientState.GetByKey(fmt.Sprintf("%s/%s/%s", kind, namespace, name))
+	if err != nil {
+		writeError(w, apierrors.NewNotFound(info.resource, name))
+		return
+	}
+	current := obj.(metav1.Object)
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	patchedJSON, err := applyPatch(r, currentJSON)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	patched := info.newObject()
+	if err := json.Unmarshal(patchedJSON, patched); err != nil {
+		writeError(w, newUnprocessablePatch(err))
+		return
+	}
+	if patched.GetName() != name || patched.GetNamespace() != namespace {
+		writeError(w, apierrors.NewBadRequest("the name and namespace of an object can not be patched"))
+		return
+	}
+	if patchedKind := patched.(interface{ GetGenerateName() string }).GetGenerateName(); patchedKind != kind {
+		writeError(w, apierrors.NewBadRequest(fmt.Sprintf("the kind of a %s can not be patched", kind)))
+		return
+	}
+	if err := checkResourceVersion(info.resource, name, patched.GetResourceVersion(), current.GetResourceVersion()); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := info.validate(patched); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := clientState.Update(patched, info.reconcile); err != nil {
+		writeError(w, err)
+		return
+	}
+	if secret, ok := patched.(*types.Secret); ok && !includeData {
+		writeObject(w, http.StatusOK, redactSecret(secret))
+		return
+	}
+	writeObject(w, http.StatusOK, patched)
+}
+
+// isJSONPatchRequest reports whether the body of the request is a JSON patch
+func isJSONPatchRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == jsonPatchType
+}
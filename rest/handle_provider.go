@@ -3,12 +3,12 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
 	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog/v2"
 )
 
@@ -33,8 +33,7 @@ func returnSingleProvider(w http.ResponseWriter, r *http.Request, clientState ca
 	namespace := vars["namespace"]
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Provider/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Provider Not Found\n")
+		writeError(w, apierrors.NewNotFound(providerResource, name))
 		return
 	}
 	provider := obj.(*types.Provider)
@@ -43,12 +42,32 @@ func returnSingleProvider(w http.ResponseWriter, r *http.Request, clientState ca
 
 func createNewProvider(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
 	klog.Info("Endpoint Hit: createNewProvider")
-	reqBody, _ := ioutil.ReadAll(r.Body)
 	var provider types.Provider
-	json.Unmarshal(reqBody, &provider)
-	clientState.Add(&provider)
+	if err := decodeRequestBody(r, &provider, "Provider"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorizeRequest(r, "create", providerResource, provider.Namespace, provider.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateProvider(&provider); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	json.NewEncoder(w).Encode(provider)
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	key := fmt.Sprintf("Provider/%s/%s", provider.Namespace, provider.Name)
+	if _, exists, _ := clientState.GetByKey(key); exists {
+		writeError(w, apierrors.NewAlreadyExists(providerResource, provider.Name))
+		return
+	}
+	if err := clientState.Add(&provider); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusCreated, provider)
 }
 
 func updateProvider(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -56,23 +75,36 @@ func updateProvider(w http.ResponseWriter, r *http.Request, clientState cacheObj
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
-	_, _, err := clientState.GetByKey(fmt.Sprintf("Provider/%s/%s", namespace, name))
+	var provider types.Provider
+	if err := decodeRequestBody(r, &provider, "Provider"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if name != provider.ObjectMeta.Name || namespace != provider.ObjectMeta.Namespace {
+		writeError(w, apierrors.NewBadRequest("the name and namespace of the request body do not match the request URL"))
+		return
+	}
+	if err := validateProvider(&provider); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	obj, _, err := clientState.GetByKey(fmt.Sprintf("Provider/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Provider Not Found\n")
+		writeError(w, apierrors.NewNotFound(providerResource, name))
 		return
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		var provider types.Provider
-		json.Unmarshal(reqBody, &provider)
-		if name == provider.ObjectMeta.Name && namespace == provider.ObjectMeta.Namespace {
-			clientState.Update(&provider, true)
-			json.NewEncoder(w).Encode(provider)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, "Invalid Request Body\n")
-		}
 	}
+	if err := checkResourceVersion(providerResource, name, provider.ResourceVersion, obj.(*types.Provider).ResourceVersion); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := clientState.Update(&provider, true); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusOK, provider)
 }
 
 func deleteProvider(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -80,13 +112,16 @@ func deleteProvider(w http.ResponseWriter, r *http.Request, clientState cacheObj
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Provider/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Provider Not Found\n")
+		writeError(w, apierrors.NewNotFound(providerResource, name))
 		return
-	} else {
-		provider := obj.(*types.Provider)
-		clientState.Delete(provider)
+	}
+	provider := obj.(*types.Provider)
+	if err := clientState.Delete(provider); err != nil {
+		writeError(w, err)
 	}
 }
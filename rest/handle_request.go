@@ -2,69 +2,74 @@ package rest
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/klog/v2"
 )
 
+// writeLock serializes the handlers which modify the store, so that an existence check
+// and the following write can not interleave with another request
+var writeLock sync.Mutex
+
 func homePage(w http.ResponseWriter, r *http.Request) {
 	klog.Info(w, "Welcome to the HomePage!")
 	klog.Info("Endpoint Hit: homePage")
 }
 
-// HandleRequests is for creating a new instance of a mux router
-func HandleRequests(clientState cacheObj.Store) {
+// newRouter is for creating a new instance of a mux router, serving the objects of the store.
+// A nil requestAuth allows every request.
+func newRouter(clientState cacheObj.Store, auth *requestAuth, probes *probes, canceler *runCanceler) *mux.Router {
 	myRouter := mux.NewRouter().StrictSlash(true)
 	myRouter.HandleFunc("/", homePage)
 
-	myRouter.HandleFunc("/secrets", func(w http.ResponseWriter, r *http.Request) {
-		returnAllSecrets(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/secret/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		returnSingleSecret(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/secret", func(w http.ResponseWriter, r *http.Request) {
-		createNewSecret(w, r, clientState)
-	}).Methods("POST")
-	myRouter.HandleFunc("/secret/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		updateSecret(w, r, clientState)
-	}).Methods("PUT")
-	myRouter.HandleFunc("/secret/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		deleteSecret(w, r, clientState)
-	}).Methods("DELETE")
+	// The probes are not authenticated, so that they can be used by the kubelet or a load balancer
+	myRouter.HandleFunc("/healthz", probes.handler("healthz", false)).Methods("GET")
+	myRouter.HandleFunc("/readyz", probes.handler("readyz", true)).Methods("GET")
+
+	// route registers a handler, which is called once the request is authorized for verb on resource
+	route := func(path, method, verb string, resource schema.GroupResource, handler func(http.ResponseWriter, *http.Request, cacheObj.Store)) {
+		myRouter.HandleFunc(path, auth.authorized(verb, resource, func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, clientState)
+		})).Methods(method)
+	}
+
+	// Requests without the namespace in the path are authorized by their handler, once the body is decoded
+	route("/apply", "POST", "", schema.GroupResource{}, applyManifest)
+
+	route("/secrets", "GET", "list", secretResource, returnAllSecrets)
+	route("/secrets/reencrypt", "POST", "update", secretResource, reencryptSecrets)
+	route("/secret/{namespace}/{name}", "GET", "get", secretResource, returnSingleSecret)
+	route("/secret", "POST", "", secretResource, createNewSecret)
+	route("/secret/{namespace}/{name}", "PUT", "update", secretResource, updateSecret)
+	route("/secret/{namespace}/{name}", "PATCH", "patch", secretResource, func(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+		patchObject(w, r, clientState, "Secret")
+	})
+	route("/secret/{namespace}/{name}", "DELETE", "delete", secretResource, deleteSecret)
+
+	route("/providers", "GET", "list", providerResource, returnAllProviders)
+	route("/provider/{namespace}/{name}", "GET", "get", providerResource, returnSingleProvider)
+	route("/provider", "POST", "", providerResource, createNewProvider)
+	route("/provider/{namespace}/{name}", "PUT", "update", providerResource, updateProvider)
+	route("/provider/{namespace}/{name}", "PATCH", "patch", providerResource, func(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+		patchObject(w, r, clientState, "Provider")
+	})
+	route("/provider/{namespace}/{name}", "DELETE", "delete", providerResource, deleteProvider)
 
-	myRouter.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) {
-		returnAllProviders(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/provider/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		returnSingleProvider(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/provider", func(w http.ResponseWriter, r *http.Request) {
-		createNewProvider(w, r, clientState)
-	}).Methods("POST")
-	myRouter.HandleFunc("/provider/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		updateProvider(w, r, clientState)
-	}).Methods("PUT")
-	myRouter.HandleFunc("/provider/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		deleteProvider(w, r, clientState)
-	}).Methods("DELETE")
+	route("/configurations", "GET", "list", configurationResource, returnAllConfigurations)
+	route("/configuration/{namespace}/{name}", "GET", "get", configurationResource, returnSingleConfiguration)
+	route("/configuration", "POST", "", configurationResource, createNewConfiguration)
+	route("/configuration/{namespace}/{name}", "PUT", "update", configurationResource, updateConfiguration)
+	route("/configuration/{namespace}/{name}", "PATCH", "patch", configurationResource, func(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+		patchObject(w, r, clientState, "Configuration")
+	})
+	route("/configuration/{namespace}/{name}", "DELETE", "delete", configurationResource, deleteConfiguration)
+	route("/configuration/{namespace}/{name}/cancel", "POST", "update", configurationResource, canceler.cancelConfigurationRun)
 
-	myRouter.HandleFunc("/configurations", func(w http.ResponseWriter, r *http.Request) {
-		returnAllConfigurations(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/configuration/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		returnSingleConfiguration(w, r, clientState)
-	}).Methods("GET")
-	myRouter.HandleFunc("/configuration", func(w http.ResponseWriter, r *http.Request) {
-		createNewConfiguration(w, r, clientState)
-	}).Methods("POST")
-	myRouter.HandleFunc("/configuration/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		updateConfiguration(w, r, clientState)
-	}).Methods("PUT")
-	myRouter.HandleFunc("/configuration/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		deleteConfiguration(w, r, clientState)
-	}).Methods("DELETE")
+	// The Events are authorized in the namespace of the involved object of the query
+	route("/events", "GET", "", eventResource, returnEvents)
 
-	klog.Fatal(http.ListenAndServe(":10000", myRouter))
+	return myRouter
 }
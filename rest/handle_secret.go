@@ -3,23 +3,61 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
 	"github.com/ttsubo2000/terraform-controller/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog/v2"
 )
 
+// includeSecretData returns whether the request asks for the data of the Secrets with the query parameter
+// includeData=true, and checks the caller is allowed to get secrets/data
+func includeSecretData(r *http.Request, namespace, name string) (bool, error) {
+	value := r.URL.Query().Get("includeData")
+	if value == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, apierrors.NewBadRequest(fmt.Sprintf("the query parameter includeData %q is not a boolean", value))
+	}
+	if !include {
+		return false, nil
+	}
+	if err := authorizeRequest(r, "get", secretDataResource, namespace, name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// redactSecret returns a copy of the Secret without its data
+func redactSecret(secret *types.Secret) *types.Secret {
+	redacted := *secret
+	redacted.Data = nil
+	redacted.StringData = nil
+	return &redacted
+}
+
 func returnAllSecrets(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
 	klog.Info("Endpoint Hit: returnAllSecrets")
+	// The data of the Secrets of every namespace is only returned to callers allowed to get it cluster wide
+	includeData, err := includeSecretData(r, "", "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	var SecretsList []*types.Secret
 	list := clientState.List()
 	for _, obj := range list {
 		switch obj.(type) {
 		case *types.Secret:
 			secret := obj.(*types.Secret)
+			if !includeData {
+				secret = redactSecret(secret)
+			}
 			SecretsList = append(SecretsList, secret)
 		}
 	}
@@ -31,24 +69,74 @@ func returnSingleSecret(w http.ResponseWriter, r *http.Request, clientState cach
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
+	includeData, err := includeSecretData(r, namespace, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Secret/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Secret Not Found\n")
+		writeError(w, apierrors.NewNotFound(secretResource, name))
 		return
 	}
 	secret := obj.(*types.Secret)
+	if !includeData {
+		secret = redactSecret(secret)
+	}
 	json.NewEncoder(w).Encode(secret)
 }
 
+// reencryptSecrets reloads the encryption key file, and encrypts every Secret again with its primary key
+func reencryptSecrets(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
+	klog.Info("Endpoint Hit: reencryptSecrets")
+	reencrypter, ok := clientState.(cacheObj.SecretReencrypter)
+	if !ok {
+		writeError(w, apierrors.NewBadRequest(cacheObj.ErrSecretEncryptionNotConfigured.Error()))
+		return
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	count, err := reencrypter.ReencryptSecrets()
+	if err == cacheObj.ErrSecretEncryptionNotConfigured {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	if err != nil {
+		writeError(w, apierrors.NewInternalError(err))
+		return
+	}
+	writeObject(w, http.StatusOK, map[string]int{"reencrypted": count})
+}
+
 func createNewSecret(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
 	klog.Info("Endpoint Hit: createNewSecret")
-	reqBody, _ := ioutil.ReadAll(r.Body)
 	var secret types.Secret
-	json.Unmarshal(reqBody, &secret)
-	clientState.Add(&secret)
+	if err := decodeRequestBody(r, &secret, "Secret"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorizeRequest(r, "create", secretResource, secret.Namespace, secret.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateSecret(&secret); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	json.NewEncoder(w).Encode(secret)
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	key := fmt.Sprintf("Secret/%s/%s", secret.Namespace, secret.Name)
+	if _, exists, _ := clientState.GetByKey(key); exists {
+		writeError(w, apierrors.NewAlreadyExists(secretResource, secret.Name))
+		return
+	}
+	if err := clientState.Add(&secret); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusCreated, secret)
 }
 
 func updateSecret(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -56,23 +144,36 @@ func updateSecret(w http.ResponseWriter, r *http.Request, clientState cacheObj.S
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
-	_, _, err := clientState.GetByKey(fmt.Sprintf("Secret/%s/%s", namespace, name))
+	var secret types.Secret
+	if err := decodeRequestBody(r, &secret, "Secret"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if name != secret.ObjectMeta.Name || namespace != secret.ObjectMeta.Namespace {
+		writeError(w, apierrors.NewBadRequest("the name and namespace of the request body do not match the request URL"))
+		return
+	}
+	if err := validateSecret(&secret); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	obj, _, err := clientState.GetByKey(fmt.Sprintf("Secret/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Secret Not Found\n")
-		return
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		var secret types.Secret
-		json.Unmarshal(reqBody, &secret)
-		if name == secret.ObjectMeta.Name && namespace == secret.ObjectMeta.Namespace {
-			clientState.Update(&secret, false)
-			json.NewEncoder(w).Encode(secret)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, "Invalid Request Body\n")
-		}
+		writeError(w, apierrors.NewNotFound(secretResource, name))
+		return
 	}
+	if err := checkResourceVersion(secretResource, name, secret.ResourceVersion, obj.(*types.Secret).ResourceVersion); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := clientState.Update(&secret, false); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeObject(w, http.StatusOK, secret)
 }
 
 func deleteSecret(w http.ResponseWriter, r *http.Request, clientState cacheObj.Store) {
@@ -80,13 +181,16 @@ func deleteSecret(w http.ResponseWriter, r *http.Request, clientState cacheObj.S
 	vars := mux.Vars(r)
 	name := vars["name"]
 	namespace := vars["namespace"]
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
 	obj, _, err := clientState.GetByKey(fmt.Sprintf("Secret/%s/%s", namespace, name))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Secret Not Found\n")
+		writeError(w, apierrors.NewNotFound(secretResource, name))
 		return
-	} else {
-		secret := obj.(*types.Secret)
-		clientState.Delete(secret)
+	}
+	secret := obj.(*types.Secret)
+	if err := clientState.Delete(secret); err != nil {
+		writeError(w, err)
 	}
 }
@@ -0,0 +1,79 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Checker returns an error when the checked component is not healthy, or not ready
+type Checker func() error
+
+// namedCheck is a check of /healthz or /readyz
+type namedCheck struct {
+	name  string
+	check Checker
+}
+
+// probes holds the checks of /healthz and /readyz
+type probes struct {
+	mu      sync.RWMutex
+	healthz []namedCheck
+	readyz  []namedCheck
+}
+
+// AddHealthzCheck adds a check to /healthz, which fails when the controller should be restarted
+func (s *Server) AddHealthzCheck(name string, check Checker) {
+	s.probes.mu.Lock()
+	defer s.probes.mu.Unlock()
+	s.probes.healthz = append(s.probes.healthz, namedCheck{name: name, check: check})
+}
+
+// AddReadyzCheck adds a check to /readyz, which fails while the controller can not serve or reconcile
+func (s *Server) AddReadyzCheck(name string, check Checker) {
+	s.probes.mu.Lock()
+	defer s.probes.mu.Unlock()
+	s.probes.readyz = append(s.probes.readyz, namedCheck{name: name, check: check})
+}
+
+// handler serves the checks of /healthz, or of /readyz, like the health endpoints of the Kubernetes API server: "ok"
+// when every check passes, and the result of each check when one fails or with the query parameter verbose.
+// The reasons of the failures are only logged, as the endpoints are not authenticated.
+func (p *probes) handler(endpoint string, readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.mu.RLock()
+		checks := p.healthz
+		if readiness {
+			checks = p.readyz
+		}
+		p.mu.RUnlock()
+
+		var out strings.Builder
+		failed := false
+		for _, c := range checks {
+			if err := c.check(); err != nil {
+				klog.InfoS("Check failed", "Endpoint", endpoint, "Check", c.name, "Reason", err.Error())
+				fmt.Fprintf(&out, "[-]%s failed: reason withheld\n", c.name)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%s ok\n", c.name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%s%s check failed\n", out.String(), endpoint)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(w, "%s%s check passed\n", out.String(), endpoint)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+)
+
+// shutdownTimeout is how long the server waits for the requests in flight when it is stopped
+const shutdownTimeout = 10 * time.Second
+
+// Options configures the REST server
+type Options struct {
+	// BindAddress is the address the server listens on, ":10000" by default
+	BindAddress string
+	// TLSCertFile and TLSPrivateKeyFile enable serving HTTPS. The files are reloaded when they change.
+	TLSCertFile       string
+	TLSPrivateKeyFile string
+	// ClientCAFile enables the authentication of client certificates signed by one of its CAs. It requires HTTPS.
+	ClientCAFile string
+	// TokenAuthFile enables the authentication of static bearer tokens, see NewTokenFileAuthenticator
+	TokenAuthFile string
+	// ReadTimeout, WriteTimeout and IdleTimeout are the timeouts of the connections, zero means no timeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// Server serves the REST API. It is started and stopped by the manager.
+type Server struct {
+	options Options
+	server  *http.Server
+	probes  *probes
+	// runCanceler cancels the terraform runs for the cancel endpoint of the Configurations
+	runCanceler *runCanceler
+}
+
+// NewServer creates the REST server for the objects of the store
+func NewServer(clientState cacheObj.Store, opts Options) (*Server, error) {
+	if opts.BindAddress == "" {
+		opts.BindAddress = ":10000"
+	}
+	if (opts.TLSCertFile == "") != (opts.TLSPrivateKeyFile == "") {
+		return nil, errors.New("both a TLS certificate and a private key are required to serve HTTPS")
+	}
+	auth, err := buildAuth(opts, clientState)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		klog.Warning("No authentication is configured for the REST API, every request is allowed")
+	}
+	tlsConfig, err := buildTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		options:     opts,
+		probes:      &probes{},
+		runCanceler: &runCanceler{},
+	}
+	s.server = &http.Server{
+		Addr:         opts.BindAddress,
+		Handler:      newRouter(clientState, auth, s.probes, s.runCanceler),
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+	ping := func() error { return nil }
+	s.AddHealthzCheck("ping", ping)
+	s.AddReadyzCheck("ping", ping)
+	if checker, ok := clientState.(cacheObj.Checker); ok {
+		s.AddReadyzCheck("store", checker.Check)
+	}
+	return s, nil
+}
+
+// buildAuth sets up the authentication configured in the options. It returns nil when no authentication is
+// configured, in which case every request is allowed.
+func buildAuth(opts Options, clientState cacheObj.Store) (*requestAuth, error) {
+	var authenticators unionAuthenticator
+	if opts.ClientCAFile != "" {
+		authenticators = append(authenticators, X509Authenticator{})
+	}
+	if opts.TokenAuthFile != "" {
+		tokenAuthenticator, err := NewTokenFileAuthenticator(opts.TokenAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokenAuthenticator)
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return &requestAuth{
+		authenticator: authenticators,
+		authorizer:    &RBACAuthorizer{Client: clientState},
+	}, nil
+}
+
+// buildTLSConfig sets up the verification of client certificates. The serving certificate is set up by Start.
+func buildTLSConfig(opts Options) (*tls.Config, error) {
+	if opts.TLSCertFile == "" {
+		if opts.ClientCAFile != "" {
+			return nil, errors.New("client certificate authentication requires a TLS certificate and private key")
+		}
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	caPEM, err := ioutil.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the client CA file")
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.Errorf("no certificate found in the client CA file %s", opts.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	// Clients may authenticate with a bearer token instead
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// NeedLeaderElection returns false, as the REST API is served by the standby instances as well
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the REST API until ctx is done, then waits for the requests in flight to complete.
+// It returns an error when the server can not listen on its bind address.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.options.BindAddress)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", s.options.BindAddress)
+	}
+
+	if s.server.TLSConfig != nil {
+		watcher, err := certwatcher.New(s.options.TLSCertFile, s.options.TLSPrivateKeyFile)
+		if err != nil {
+			listener.Close()
+			return errors.Wrap(err, "failed to load the TLS certificate")
+		}
+		go func() {
+			if err := watcher.Start(ctx); err != nil {
+				klog.ErrorS(err, "Failed to watch the TLS certificate")
+			}
+		}()
+		s.server.TLSConfig.GetCertificate = watcher.GetCertificate
+		listener = tls.NewListener(listener, s.server.TLSConfig)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		klog.InfoS("Serving the REST API", "Address", listener.Addr().String(), "TLS", s.server.TLSConfig != nil)
+		serveErr <- s.server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	klog.Info("Stopping the REST API")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "failed to stop the REST API gracefully")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+var (
+	secretResource = schema.GroupResource{Resource: "secrets"}
+	// secretDataResource authorizes reading the data of Secrets, which is redacted otherwise
+	secretDataResource    = schema.GroupResource{Resource: "secrets/data"}
+	providerResource      = schema.GroupResource{Group: "terraform.core.oam.dev", Resource: "providers"}
+	configurationResource = schema.GroupResource{Group: "terraform.core.oam.dev", Resource: "configurations"}
+	eventResource         = schema.GroupResource{Resource: "events"}
+)
+
+// newUnsupportedMediaType returns an error indicating the request body is in a format the server can not decode
+func newUnsupportedMediaType(contentType string) *apierrors.StatusError {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusUnsupportedMediaType,
+		Reason:  metav1.StatusReasonUnsupportedMediaType,
+		Message: fmt.Sprintf("the body of the request was in an unknown format %q, only application/json and application/yaml are supported", contentType),
+	}}
+}
+
+// isYAMLRequest reports whether the body of the request is YAML rather than JSON
+func isYAMLRequest(r *http.Request) (bool, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return false, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, newUnsupportedMediaType(contentType)
+	}
+	switch mediaType {
+	case "application/json":
+		return false, nil
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return true, nil
+	default:
+		return false, newUnsupportedMediaType(contentType)
+	}
+}
+
+// readRequestBody reads the body of the request and converts it to JSON when it is sent as YAML
+func readRequestBody(r *http.Request) ([]byte, error) {
+	isYAML, err := isYAMLRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to read the request body: %v", err))
+	}
+	if isYAML {
+		if reqBody, err = yaml.YAMLToJSON(reqBody); err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("the request body is not valid YAML: %v", err))
+		}
+	}
+	return reqBody, nil
+}
+
+// decodeRequestBody reads the body of the request and decodes it into obj.
+// The kind of the object is defaulted to kind, and rejected if it names another kind.
+func decodeRequestBody(r *http.Request, obj interface{}, kind string) error {
+	reqBody, err := readRequestBody(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(reqBody, obj); err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("the request body is not valid JSON: %v", err))
+	}
+	typeMeta := obj.(interface{ GetObjectKind() schema.ObjectKind }).GetObjectKind()
+	gvk := typeMeta.GroupVersionKind()
+	switch gvk.Kind {
+	case "":
+		gvk.Kind = kind
+		typeMeta.SetGroupVersionKind(gvk)
+	case kind:
+	default:
+		return apierrors.NewBadRequest(fmt.Sprintf("the kind %q of the request body does not match the expected kind %q", gvk.Kind, kind))
+	}
+	return nil
+}
+
+// checkResourceVersion rejects a write which is based on an outdated version of the object.
+// An empty resource version makes the write unconditional.
+func checkResourceVersion(qualifiedResource schema.GroupResource, name, requested, current string) error {
+	if requested == "" || requested == current {
+		return nil
+	}
+	return apierrors.NewConflict(qualifiedResource, name,
+		fmt.Errorf("the object has been modified; please apply your changes to the latest version (resourceVersion %s) and try again", current))
+}
+
+// writeObject encodes obj as the JSON response with the given status code
+func writeObject(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// statusOf converts err to a Status. Errors which do not carry a status are reported as internal errors.
+func statusOf(err error) metav1.Status {
+	statusErr, ok := err.(apierrors.APIStatus)
+	if !ok {
+		statusErr = apierrors.NewInternalError(err)
+	}
+	status := statusErr.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	return status
+}
+
+// writeError encodes err as a Status response
+func writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	klog.InfoS("Request failed", "Code", status.Code, "Reason", status.Reason, "Message", status.Message)
+	writeObject(w, int(status.Code), status)
+}
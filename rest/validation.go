@@ -0,0 +1,217 @@
+package rest
+
+import (
+	"regexp"
+
+	crossplanetypes "github.com/oam-dev/terraform-controller/api/types/crossplane-runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	tfcfg "github.com/ttsubo2000/terraform-controller/controllers/configuration"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+var (
+	secretKind        = schema.GroupKind{Kind: "Secret"}
+	providerKind      = schema.GroupKind{Group: "terraform.core.oam.dev", Kind: "Provider"}
+	configurationKind = schema.GroupKind{Group: "terraform.core.oam.dev", Kind: "Configuration"}
+)
+
+// providerAliasRegexp matches the aliases of provider blocks, which also name Terraform variables
+var providerAliasRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// validateObjectMeta checks the name and namespace every stored object needs
+func validateObjectMeta(meta *metav1.ObjectMeta, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if meta.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(meta.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), meta.Name, msg))
+		}
+	}
+	if meta.Namespace == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("namespace"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(meta.Namespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("namespace"), meta.Namespace, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateConfiguration validates a Configuration before it is stored
+func validateConfiguration(configuration *types.Configuration) error {
+	allErrs := validateObjectMeta(&configuration.ObjectMeta, field.NewPath("metadata"))
+	specPath := field.NewPath("spec")
+	if _, err := tfcfg.ValidConfigurationObject(configuration); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath, "", err.Error()))
+	}
+	if ref := configuration.Spec.ProviderReference; ref != nil && ref.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("providerRef", "name"), ""))
+	}
+	allErrs = append(allErrs, validateProviderReferences(&configuration.Spec, specPath)...)
+	allErrs = append(allErrs, validateTimeouts(configuration.Spec.Timeouts, specPath.Child("timeouts"))...)
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(configurationKind, configuration.Name, allErrs)
+	}
+	return nil
+}
+
+// validateProviderReferences checks the providerRefs have distinct aliases, and at most one has none
+func validateProviderReferences(spec *types.ConfigurationSpec, specPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	refsPath := specPath.Child("providerRefs")
+	if len(spec.ProviderReferences) > 0 && spec.ProviderReference != nil {
+		allErrs = append(allErrs, field.Forbidden(refsPath, "providerRef and providerRefs can not be set together"))
+	}
+	aliases := map[string]bool{}
+	for i, ref := range spec.ProviderReferences {
+		refPath := refsPath.Index(i)
+		if ref.Name == "" {
+			allErrs = append(allErrs, field.Required(refPath.Child("name"), ""))
+		}
+		if ref.Alias != "" && !providerAliasRegexp.MatchString(ref.Alias) {
+			allErrs = append(allErrs, field.Invalid(refPath.Child("alias"), ref.Alias,
+				"must start with a letter, and only contain letters, digits and underscores"))
+		}
+		if aliases[ref.Alias] {
+			if ref.Alias == "" {
+				allErrs = append(allErrs, field.Invalid(refPath.Child("alias"), ref.Alias, "only one Provider can have no alias"))
+			} else {
+				allErrs = append(allErrs, field.Duplicate(refPath.Child("alias"), ref.Alias))
+			}
+		}
+		aliases[ref.Alias] = true
+	}
+	return allErrs
+}
+
+// validateTimeouts checks the timeouts are not negative
+func validateTimeouts(timeouts *types.Timeouts, timeoutsPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if timeouts == nil {
+		return allErrs
+	}
+	names := []string{"init", "plan", "apply", "destroy"}
+	for i, timeout := range []*metav1.Duration{timeouts.Init, timeouts.Plan, timeouts.Apply, timeouts.Destroy} {
+		if timeout != nil && timeout.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(timeoutsPath.Child(names[i]), timeout.Duration.String(), "must not be negative"))
+		}
+	}
+	return allErrs
+}
+
+// validateProvider validates a Provider before it is stored
+func validateProvider(provider *types.Provider) error {
+	allErrs := validateObjectMeta(&provider.ObjectMeta, field.NewPath("metadata"))
+	specPath := field.NewPath("spec")
+	if provider.Spec.Provider == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("provider"), ""))
+	}
+	credentialsPath := specPath.Child("credentials")
+	switch provider.Spec.Credentials.Source {
+	case "":
+		allErrs = append(allErrs, field.Required(credentialsPath.Child("source"), ""))
+	case "Secret":
+		secretRef := provider.Spec.Credentials.SecretRef
+		if secretRef.Name == "" {
+			allErrs = append(allErrs, field.Required(credentialsPath.Child("secretRef", "name"), ""))
+		}
+		if secretRef.Key == "" {
+			allErrs = append(allErrs, field.Required(credentialsPath.Child("secretRef", "key"), ""))
+		}
+	case types.CredentialsSourceFilesystem:
+		if fs := provider.Spec.Credentials.Fs; fs == nil || fs.Path == "" {
+			allErrs = append(allErrs, field.Required(credentialsPath.Child("fs", "path"), ""))
+		}
+	case types.CredentialsSourceEnvironment:
+		env := provider.Spec.Credentials.Env
+		switch {
+		case env == nil || (env.Name == "" && len(env.Mapping) == 0):
+			allErrs = append(allErrs, field.Required(credentialsPath.Child("env"), "either name or mapping is required"))
+		case env.Name != "" && len(env.Mapping) > 0:
+			allErrs = append(allErrs, field.Invalid(credentialsPath.Child("env"), env, "name and mapping are mutually exclusive"))
+		}
+	case types.CredentialsSourceVault:
+		allErrs = append(allErrs, validateVaultSelector(provider.Spec.Credentials.Vault, credentialsPath.Child("vault"))...)
+	case crossplanetypes.CredentialsSourceInjectedIdentity:
+	default:
+		allErrs = append(allErrs, field.NotSupported(credentialsPath.Child("source"), provider.Spec.Credentials.Source,
+			[]string{"Secret", string(types.CredentialsSourceFilesystem), string(types.CredentialsSourceEnvironment),
+				string(types.CredentialsSourceVault), string(crossplanetypes.CredentialsSourceInjectedIdentity)}))
+	}
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(providerKind, provider.Name, allErrs)
+	}
+	return nil
+}
+
+// validateVaultSelector checks the Vault secret has a path, and at most one auth with its references
+func validateVaultSelector(vault *types.VaultSelector, vaultPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if vault == nil {
+		return append(allErrs, field.Required(vaultPath, ""))
+	}
+	if vault.Path == "" {
+		allErrs = append(allErrs, field.Required(vaultPath.Child("path"), ""))
+	}
+	authPath := vaultPath.Child("auth")
+	if vault.Auth.TokenSecretRef != nil && vault.Auth.AppRole != nil {
+		allErrs = append(allErrs, field.Forbidden(authPath.Child("appRole"), "tokenSecretRef and appRole are mutually exclusive"))
+	}
+	if ref := vault.Auth.TokenSecretRef; ref != nil {
+		allErrs = append(allErrs, validateSecretKeySelector(*ref, authPath.Child("tokenSecretRef"))...)
+	}
+	if appRole := vault.Auth.AppRole; appRole != nil {
+		if appRole.RoleID == "" {
+			allErrs = append(allErrs, field.Required(authPath.Child("appRole", "roleID"), ""))
+		}
+		allErrs = append(allErrs, validateSecretKeySelector(appRole.SecretIDRef, authPath.Child("appRole", "secretIDRef"))...)
+	}
+	return allErrs
+}
+
+func validateSecretKeySelector(ref crossplanetypes.SecretKeySelector, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	if ref.Key == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("key"), ""))
+	}
+	return allErrs
+}
+
+// validateSecret validates a Secret before it is stored
+func validateSecret(secret *types.Secret) error {
+	allErrs := validateObjectMeta(&secret.ObjectMeta, field.NewPath("metadata"))
+	dataPath := field.NewPath("data")
+	totalSize := 0
+	for key, value := range secret.Data {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			allErrs = append(allErrs, field.Invalid(dataPath.Key(key), key, msg))
+		}
+		totalSize += len(value)
+	}
+	stringDataPath := field.NewPath("stringData")
+	for key, value := range secret.StringData {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			allErrs = append(allErrs, field.Invalid(stringDataPath.Key(key), key, msg))
+		}
+		if _, ok := secret.Data[key]; ok {
+			totalSize -= len(secret.Data[key])
+		}
+		totalSize += len(value)
+	}
+	if totalSize > types.MaxSecretSize {
+		allErrs = append(allErrs, field.TooLong(dataPath, "", types.MaxSecretSize))
+	}
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(secretKind, secret.Name, allErrs)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+// sealedValuePrefix prefixes the values of the Secrets encrypted by a SecretEncryption
+const sealedValuePrefix = "enc:aesgcm:v1:"
+
+// dataKeySize is the size of the data keys, which encrypt the values of the Secrets
+const dataKeySize = 32
+
+// SecretEncryption encrypts the data of the Secrets of a Store with AES-GCM. Each value is encrypted with its own
+// data key, which is encrypted with the primary key of the key file (envelope encryption). The other keys of the
+// file only decrypt, so that keys can be rotated.
+type SecretEncryption struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []*encryptionKey
+}
+
+// encryptionKey is a key encryption key of the key file
+type encryptionKey struct {
+	name string
+	aead cipher.AEAD
+}
+
+// encryptionKeyFile is the format of the key file, the first key is the primary key
+type encryptionKeyFile struct {
+	Keys []struct {
+		Name string `yaml:"name"`
+		// Secret is the base64 encoded AES key of 16, 24 or 32 bytes
+		Secret string `yaml:"secret"`
+	} `yaml:"keys"`
+}
+
+// SecretReencrypter is implemented by the Stores which encrypt Secrets
+type SecretReencrypter interface {
+	// ReencryptSecrets reloads the key file, and encrypts every Secret again with the primary key.
+	// It returns the number of Secrets encrypted again.
+	ReencryptSecrets() (int, error)
+}
+
+// NewSecretEncryption loads the keys of the key file
+func NewSecretEncryption(path string) (*SecretEncryption, error) {
+	keys, err := loadEncryptionKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretEncryption{path: path, keys: keys}, nil
+}
+
+func loadEncryptionKeys(path string) ([]*encryptionKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the encryption key file")
+	}
+	var file encryptionKeyFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the encryption key file")
+	}
+	if len(file.Keys) == 0 {
+		return nil, errors.New("the encryption key file has no keys")
+	}
+	keys := make([]*encryptionKey, 0, len(file.Keys))
+	names := map[string]bool{}
+	for i, k := range file.Keys {
+		if k.Name == "" || strings.Contains(k.Name, ":") {
+			return nil, errors.Errorf("the name of the encryption key %d is empty or contains a colon", i)
+		}
+		if names[k.Name] {
+			return nil, errors.Errorf("the encryption key %s is duplicated", k.Name)
+		}
+		names[k.Name] = true
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "the encryption key %s is not base64 encoded", k.Name)
+		}
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "the encryption key %s is not a valid AES key", k.Name)
+		}
+		keys = append(keys, &encryptionKey{name: k.Name, aead: aead})
+	}
+	return keys, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns a copy of the Secret whose values are encrypted with the primary key
+func (e *SecretEncryption) seal(secret *types.Secret) (*types.Secret, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return transformSecret(secret, func(ad string, value []byte) ([]byte, error) {
+		return sealValue(e.keys[0], ad, value)
+	})
+}
+
+// open returns a copy of the sealed Secret whose values are decrypted
+func (e *SecretEncryption) open(sealed *types.Secret) (*types.Secret, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return transformSecret(sealed, func(ad string, value []byte) ([]byte, error) {
+		return openValue(e.keys, ad, value)
+	})
+}
+
+// transformSecret returns a copy of the Secret whose values are transformed by f. The values are bound to the
+// namespace, name and key of the Secret, so that they can not be moved to another one. The stringData of the
+// Secret is merged into its data before it is stored, so only the data is transformed.
+func transformSecret(secret *types.Secret, f func(ad string, value []byte) ([]byte, error)) (*types.Secret, error) {
+	out := *secret
+	if secret.Data == nil {
+		return &out, nil
+	}
+	out.Data = make(map[string][]byte, len(secret.Data))
+	for k, v := range secret.Data {
+		value, err := f(secret.Namespace+"/"+secret.Name+"/"+k, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "the key %s of the Secret %s/%s", k, secret.Namespace, secret.Name)
+		}
+		out.Data[k] = value
+	}
+	return &out, nil
+}
+
+// sealValue encrypts a new data key with the key, and the value with the data key
+func sealValue(key *encryptionKey, ad string, value []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keyNonce := make([]byte, key.aead.NonceSize())
+	dataNonce := make([]byte, dataAEAD.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, keyNonce); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, dataNonce); err != nil {
+		return nil, err
+	}
+	payload := append(keyNonce, key.aead.Seal(nil, keyNonce, dataKey, []byte(key.name))...)
+	payload = append(payload, dataNonce...)
+	payload = dataAEAD.Seal(payload, dataNonce, value, []byte(ad))
+	return []byte(sealedValuePrefix + key.name + ":" + base64.StdEncoding.EncodeToString(payload)), nil
+}
+
+// openValue decrypts a value sealed with one of the keys
+func openValue(keys []*encryptionKey, ad string, sealed []byte) ([]byte, error) {
+	value := string(sealed)
+	if !strings.HasPrefix(value, sealedValuePrefix) {
+		return nil, errors.New("the value is not encrypted")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedValuePrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("the encrypted value is malformed")
+	}
+	var key *encryptionKey
+	for _, k := range keys {
+		if k.name == parts[0] {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, errors.Errorf("the encryption key %s is not in the key file", parts[0])
+	}
+	payload, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "the encrypted value is malformed")
+	}
+	wrappedKeySize := key.aead.NonceSize() + dataKeySize + key.aead.Overhead()
+	if len(payload) < wrappedKeySize {
+		return nil, errors.New("the encrypted value is truncated")
+	}
+	dataKey, err := key.aead.Open(nil, payload[:key.aead.NonceSize()], payload[key.aead.NonceSize():wrappedKeySize], []byte(key.name))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt the data key")
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[wrappedKeySize:]
+	if len(payload) < dataAEAD.NonceSize() {
+		return nil, errors.New("the encrypted value is truncated")
+	}
+	plain, err := dataAEAD.Open(nil, payload[:dataAEAD.NonceSize()], payload[dataAEAD.NonceSize():], []byte(ad))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt the value")
+	}
+	return plain, nil
+}
+
+// rotate reloads the key file, and returns a function which decrypts with the former and the new keys, then
+// encrypts with the new primary key. The new keys are used once commit is called.
+func (e *SecretEncryption) rotate() (reseal func(*types.Secret) (*types.Secret, error), commit func(), err error) {
+	keys, err := loadEncryptionKeys(e.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.mu.RLock()
+	decryptKeys := append(append([]*encryptionKey{}, e.keys...), keys...)
+	e.mu.RUnlock()
+	reseal = func(sealed *types.Secret) (*types.Secret, error) {
+		plain, err := transformSecret(sealed, func(ad string, value []byte) ([]byte, error) {
+			return openValue(decryptKeys, ad, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return transformSecret(plain, func(ad string, value []byte) ([]byte, error) {
+			return sealValue(keys[0], ad, value)
+		})
+	}
+	commit = func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.keys = keys
+	}
+	return reseal, commit, nil
+}
+
+// String describes the keys without their secrets
+func (e *SecretEncryption) String() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.keys))
+	for _, k := range e.keys {
+		names = append(names, k.name)
+	}
+	return fmt.Sprintf("AES-GCM keys %v of %s", names, e.path)
+}
@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttsubo2000/terraform-controller/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// writeKeyFile writes a key file with the keys name:secret, the first one being the primary key
+func writeKeyFile(t *testing.T, path string, keys ...string) {
+	t.Helper()
+	var file strings.Builder
+	file.WriteString("keys:\n")
+	for _, key := range keys {
+		parts := strings.SplitN(key, ":", 2)
+		fmt.Fprintf(&file, "- name: %s\n  secret: %s\n", parts[0], base64.StdEncoding.EncodeToString([]byte(parts[1])))
+	}
+	if err := ioutil.WriteFile(path, []byte(file.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newEncryptedTestStore returns an encrypted Store with the keys of the key file path
+func newEncryptedTestStore(t *testing.T, path string, keys ...string) *Cache {
+	t.Helper()
+	writeKeyFile(t, path, keys...)
+	encryption, err := NewSecretEncryption(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedStore(MetaNamespaceKeyFunc, encryption).(*Cache)
+}
+
+func newTestSecret(name string, data map[string][]byte) *types.Secret {
+	return &types.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       data,
+	}
+}
+
+// storedSecret returns the Secret of key as it is stored, encrypted
+func storedSecret(t *testing.T, c *Cache, key string) *types.Secret {
+	t.Helper()
+	item, exists := c.cacheStorage.Get(key)
+	if !exists {
+		t.Fatalf("expected %s to be stored", key)
+	}
+	return item.(*types.Secret)
+}
+
+const (
+	key1 = "k1:0123456789abcdef0123456789abcdef"
+	key2 = "k2:fedcba9876543210fedcba9876543210"
+)
+
+func TestSecretEncryptionRoundTrip(t *testing.T) {
+	c := newEncryptedTestStore(t, filepath.Join(t.TempDir(), "keys.yaml"), key1)
+	secret := newTestSecret("s1", map[string][]byte{"password": []byte("p@ss"), "binary": {0, 1, 2, 255}})
+	if err := c.Add(secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "p@ss" {
+		t.Error("expected the added Secret to be left unencrypted")
+	}
+
+	stored := storedSecret(t, c, "Secret/default/s1")
+	for k, v := range stored.Data {
+		if !strings.HasPrefix(string(v), sealedValuePrefix+"k1:") || bytes.Contains(v, []byte("p@ss")) {
+			t.Errorf("expected the value of %s to be encrypted with k1, got %q", k, v)
+		}
+	}
+	// Each value has its own data key and nonces
+	if err := c.Update(newTestSecret("s1", map[string][]byte{"password": []byte("p@ss")}), false); err != nil {
+		t.Fatal(err)
+	}
+	if again := storedSecret(t, c, "Secret/default/s1"); bytes.Equal(again.Data["password"], stored.Data["password"]) {
+		t.Error("expected the same value to be encrypted differently")
+	}
+
+	obj, _, err := c.GetByKey("Secret/default/s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.(*types.Secret).Data["password"]; string(got) != "p@ss" {
+		t.Errorf("expected the value to be decrypted, got %q", got)
+	}
+}
+
+func TestSecretEncryptionBindsTheValuesToTheirSecret(t *testing.T) {
+	c := newEncryptedTestStore(t, filepath.Join(t.TempDir(), "keys.yaml"), key1)
+	if err := c.Add(newTestSecret("s1", map[string][]byte{"password": []byte("p@ss")})); err != nil {
+		t.Fatal(err)
+	}
+	sealed := storedSecret(t, c, "Secret/default/s1").Data["password"]
+
+	// A value moved to another Secret, or another key, is not decrypted
+	c.cacheStorage.Add("Secret/default/s2", newTestSecret("s2", map[string][]byte{"password": sealed}))
+	if _, _, err := c.GetByKey("Secret/default/s2"); err == nil {
+		t.Error("expected a value moved to another Secret not to be decrypted")
+	}
+	c.cacheStorage.Add("Secret/default/s1", newTestSecret("s1", map[string][]byte{"token": sealed}))
+	if _, _, err := c.GetByKey("Secret/default/s1"); err == nil {
+		t.Error("expected a value moved to another key not to be decrypted")
+	}
+
+	// A tampered value is not decrypted
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-10] ^= 1
+	c.cacheStorage.Add("Secret/default/s1", newTestSecret("s1", map[string][]byte{"password": tampered}))
+	if _, _, err := c.GetByKey("Secret/default/s1"); err == nil {
+		t.Error("expected a tampered value not to be decrypted")
+	}
+}
+
+func TestSecretEncryptionWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	c := newEncryptedTestStore(t, filepath.Join(dir, "keys.yaml"), key1)
+	if err := c.Add(newTestSecret("s1", map[string][]byte{"password": []byte("p@ss")})); err != nil {
+		t.Fatal(err)
+	}
+	sealed := storedSecret(t, c, "Secret/default/s1")
+
+	for name, keys := range map[string][]string{
+		"another secret of the same key name": {"k1:fedcba9876543210fedcba9876543210"},
+		"a key file without the key":          {key2},
+	} {
+		other := newEncryptedTestStore(t, filepath.Join(dir, "other.yaml"), keys...)
+		other.cacheStorage.Add("Secret/default/s1", sealed)
+		if _, _, err := other.GetByKey("Secret/default/s1"); err == nil {
+			t.Errorf("expected the Secret not to be decrypted with %s", name)
+		}
+	}
+}
+
+func TestSecretEncryptionListSkipsTheSecretsWhichCanNotBeDecrypted(t *testing.T) {
+	c := newEncryptedTestStore(t, filepath.Join(t.TempDir(), "keys.yaml"), key1)
+	if err := c.Add(newTestSecret("s1", map[string][]byte{"password": []byte("p@ss")})); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(&types.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "cm1", Namespace: "default"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.cacheStorage.Add("Secret/default/s2", newTestSecret("s2", map[string][]byte{"password": []byte("plain")}))
+
+	var names []string
+	for _, item := range c.List() {
+		switch obj := item.(type) {
+		case *types.Secret:
+			if string(obj.Data["password"]) != "p@ss" {
+				t.Errorf("expected the listed Secret to be decrypted, got %q", obj.Data["password"])
+			}
+			names = append(names, obj.Name)
+		case *types.ConfigMap:
+			names = append(names, obj.Name)
+		}
+	}
+	if len(names) != 2 {
+		t.Errorf("expected the Secret which can not be decrypted to be skipped, got %v", names)
+	}
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	c := newEncryptedTestStore(t, path, key1)
+	for _, name := range []string{"s1", "s2"} {
+		if err := c.Add(newTestSecret(name, map[string][]byte{"password": []byte("p@ss-" + name)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// k2 becomes the primary key, k1 still decrypts
+	writeKeyFile(t, path, key2, key1)
+	count, err := c.ReencryptSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected the 2 Secrets to be encrypted again, got %d", count)
+	}
+	for _, name := range []string{"s1", "s2"} {
+		if v := storedSecret(t, c, "Secret/default/"+name).Data["password"]; !strings.HasPrefix(string(v), sealedValuePrefix+"k2:") {
+			t.Errorf("expected %s to be encrypted with k2, got %q", name, v)
+		}
+	}
+	if err := c.Add(newTestSecret("s3", map[string][]byte{"password": []byte("p@ss-s3")})); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedSecret(t, c, "Secret/default/s3").Data["password"]; !strings.HasPrefix(string(v), sealedValuePrefix+"k2:") {
+		t.Errorf("expected the new Secrets to be encrypted with k2, got %q", v)
+	}
+
+	// k1 is removed once nothing is encrypted with it anymore
+	writeKeyFile(t, path, key2)
+	if _, err := c.ReencryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"s1", "s2", "s3"} {
+		obj, _, err := c.GetByKey("Secret/default/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := obj.(*types.Secret).Data["password"]; string(got) != "p@ss-"+name {
+			t.Errorf("expected %s to be decrypted with k2, got %q", name, got)
+		}
+	}
+	if c.encryption.String() != fmt.Sprintf("AES-GCM keys [k2] of %s", path) {
+		t.Errorf("unexpected keys %s", c.encryption.String())
+	}
+
+	// An invalid key file leaves the Secrets and the keys as they were
+	before := storedSecret(t, c, "Secret/default/s1").Data["password"]
+	if err := ioutil.WriteFile(path, []byte("keys: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReencryptSecrets(); err == nil {
+		t.Error("expected an invalid key file to be rejected")
+	}
+	if after := storedSecret(t, c, "Secret/default/s1").Data["password"]; !bytes.Equal(before, after) {
+		t.Error("expected the Secrets to be left as they were")
+	}
+}
+
+func TestReencryptSecretsWithoutEncryption(t *testing.T) {
+	c := NewStore(MetaNamespaceKeyFunc).(*Cache)
+	if _, err := c.ReencryptSecrets(); err != ErrSecretEncryptionNotConfigured {
+		t.Errorf("expected ErrSecretEncryptionNotConfigured, got %v", err)
+	}
+}
+
+func TestSecretEncryptionKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	for name, file := range map[string]string{
+		"no keys":             "keys: []\n",
+		"an empty name":       "keys:\n- name: \"\"\n  secret: MDEyMzQ1Njc4OWFiY2RlZg==\n",
+		"a colon in a name":   "keys:\n- name: a:b\n  secret: MDEyMzQ1Njc4OWFiY2RlZg==\n",
+		"a duplicated name":   "keys:\n- name: k1\n  secret: MDEyMzQ1Njc4OWFiY2RlZg==\n- name: k1\n  secret: MDEyMzQ1Njc4OWFiY2RlZg==\n",
+		"a secret not base64": "keys:\n- name: k1\n  secret: \"not base64\"\n",
+		"a short secret":      "keys:\n- name: k1\n  secret: c2hvcnQ=\n",
+		"an unknown field":    "keys:\n- name: k1\n  secret: MDEyMzQ1Njc4OWFiY2RlZg==\n  algorithm: des\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSecretEncryption(path); err == nil {
+			t.Errorf("expected a key file with %s to be rejected", name)
+		}
+	}
+}
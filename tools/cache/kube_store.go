@@ -0,0 +1,430 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ttsubo/client-go/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	k8scache "k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// kubeRequestTimeout is the timeout of the requests of the KubeStore to the API server
+const kubeRequestTimeout = 30 * time.Second
+
+// kubeResource is the resource of the objects of a kind in the Kubernetes API server
+type kubeResource struct {
+	kind       string
+	gvr        schema.GroupVersionResource
+	namespaced bool
+	newObject  func() runtime.Object
+}
+
+// kubeResources are the kinds of objects the KubeStore keeps, the Configurations and Providers being the custom
+// resources of the CRDs of config/crd
+var kubeResources = []kubeResource{
+	{"Configuration", schema.GroupVersionResource{Group: "terraform.core.oam.dev", Version: "v1beta2", Resource: "configurations"}, true, func() runtime.Object { return &types.Configuration{} }},
+	{"Provider", schema.GroupVersionResource{Group: "terraform.core.oam.dev", Version: "v1beta1", Resource: "providers"}, true, func() runtime.Object { return &types.Provider{} }},
+	{"Secret", v1.SchemeGroupVersion.WithResource("secrets"), true, func() runtime.Object { return &types.Secret{} }},
+	{"ConfigMap", v1.SchemeGroupVersion.WithResource("configmaps"), true, func() runtime.Object { return &types.ConfigMap{} }},
+	{"Event", v1.SchemeGroupVersion.WithResource("events"), true, func() runtime.Object { return &types.Event{} }},
+	{"ServiceAccount", v1.SchemeGroupVersion.WithResource("serviceaccounts"), true, func() runtime.Object { return &v1.ServiceAccount{} }},
+	{"ClusterRole", rbacv1.SchemeGroupVersion.WithResource("clusterroles"), false, func() runtime.Object { return &rbacv1.ClusterRole{} }},
+	{"ClusterRoleBinding", rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings"), false, func() runtime.Object { return &rbacv1.ClusterRoleBinding{} }},
+	{"Role", rbacv1.SchemeGroupVersion.WithResource("roles"), true, func() runtime.Object { return &rbacv1.Role{} }},
+	{"RoleBinding", rbacv1.SchemeGroupVersion.WithResource("rolebindings"), true, func() runtime.Object { return &rbacv1.RoleBinding{} }},
+}
+
+// resourceForKind returns the resource of the objects of kind
+func resourceForKind(kind string) (*kubeResource, error) {
+	for i := range kubeResources {
+		if kubeResources[i].kind == kind {
+			return &kubeResources[i], nil
+		}
+	}
+	return nil, errors.Errorf("the kind %q is not stored in the Kubernetes API server", kind)
+}
+
+// resourceForObject returns the resource of obj, from its type as its kind may not be set
+func resourceForObject(obj interface{}) (*kubeResource, error) {
+	for i := range kubeResources {
+		if reflect.TypeOf(kubeResources[i].newObject()) == reflect.TypeOf(obj) {
+			return &kubeResources[i], nil
+		}
+	}
+	return nil, errors.Errorf("the objects of type %T are not stored in the Kubernetes API server", obj)
+}
+
+// watched tells whether the objects of the resource are reconciled by a controller
+func (r *kubeResource) watched() bool {
+	return r.kind == "Configuration" || r.kind == "Provider"
+}
+
+// KubeStore is a Store of the objects of a Kubernetes API server. The objects are written to, and read from, the
+// API server, and listed from informers. The Configurations and Providers written by others, like kubectl, are
+// injected into the workqueue of their controller, while those written by the KubeStore are injected as by the
+// in-memory Cache.
+type KubeStore struct {
+	client  dynamic.Interface
+	factory dynamicinformer.DynamicSharedInformerFactory
+	synced  int32
+
+	// watchedLock serializes the writes of the watched objects with their watch events, so that the events of
+	// the writes of the KubeStore are recognized by ownWrites, the resource versions they wrote per key
+	watchedLock sync.Mutex
+	ownWrites   map[string]string
+
+	// setup informer
+	InformerConfig   cache.Controller
+	InformerProvider cache.Controller
+}
+
+var _ Store = &KubeStore{}
+var _ Checker = &KubeStore{}
+
+// NewKubeStore returns a Store of the objects of the Kubernetes API server of config
+func NewKubeStore(config *rest.Config) (*KubeStore, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the client of the Kubernetes API server")
+	}
+	return NewKubeStoreForClient(client), nil
+}
+
+// NewKubeStoreForClient returns a Store of the objects of client, like the fake dynamic client of client-go
+func NewKubeStoreForClient(client dynamic.Interface) *KubeStore {
+	s := &KubeStore{
+		client:    client,
+		factory:   dynamicinformer.NewDynamicSharedInformerFactory(client, 0),
+		ownWrites: map[string]string{},
+	}
+	for i := range kubeResources {
+		res := &kubeResources[i]
+		informer := s.factory.ForResource(res.gvr).Informer()
+		if !res.watched() {
+			continue
+		}
+		informer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { s.onWatchEvent(res, obj) },
+			UpdateFunc: func(_, obj interface{}) { s.onWatchEvent(res, obj) },
+			DeleteFunc: func(obj interface{}) { s.onWatchDelete(res, obj) },
+		})
+	}
+	return s
+}
+
+// Start runs the informers until ctx is done
+func (s *KubeStore) Start(ctx context.Context) error {
+	s.factory.Start(ctx.Done())
+	for gvr, synced := range s.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced && ctx.Err() == nil {
+			return errors.Errorf("failed to sync the informer of %s", gvr.String())
+		}
+	}
+	atomic.StoreInt32(&s.synced, 1)
+	klog.Info("Synced the informers of the Kubernetes store")
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection returns false, as the REST API of the standby instances lists the objects as well
+func (s *KubeStore) NeedLeaderElection() bool {
+	return false
+}
+
+// Check returns an error until the informers are synced, and when the API server or the CRDs are unavailable
+func (s *KubeStore) Check() error {
+	if atomic.LoadInt32(&s.synced) == 0 {
+		return errors.New("the informers of the Kubernetes store are not synced")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.client.Resource(kubeResources[0].gvr).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+		return errors.Wrap(err, "the Kubernetes API server is unavailable")
+	}
+	return nil
+}
+
+// Add creates obj in the API server, or replaces it when it exists
+func (s *KubeStore) Add(obj interface{}) error {
+	res, u, err := s.toUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	key := res.kind + "/" + u.GetNamespace() + "/" + u.GetName()
+	if res.watched() {
+		s.watchedLock.Lock()
+		defer s.watchedLock.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeRequestTimeout)
+	defer cancel()
+	client := s.resourceClient(res, u.GetNamespace())
+	u.SetResourceVersion("")
+	written, err := client.Create(ctx, u, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// The existing object is replaced, as in the in-memory Cache
+		var current *unstructured.Unstructured
+		if current, err = client.Get(ctx, u.GetName(), metav1.GetOptions{}); err == nil {
+			u.SetResourceVersion(current.GetResourceVersion())
+			written, err = client.Update(ctx, u, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", key)
+	}
+	s.written(res, key, obj, written)
+	klog.Infof("Update key:[%s], resourceVersion:[%s]", key, written.GetResourceVersion())
+	s.inject(obj)
+	return nil
+}
+
+// Update updates obj in the API server. The update is rejected when obj is not at the resource version of the API
+// server, it is unconditional when obj has no resource version.
+func (s *KubeStore) Update(obj interface{}, reconciliationLoop bool) error {
+	res, u, err := s.toUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	key := res.kind + "/" + u.GetNamespace() + "/" + u.GetName()
+	if res.watched() {
+		s.watchedLock.Lock()
+		defer s.watchedLock.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeRequestTimeout)
+	defer cancel()
+	client := s.resourceClient(res, u.GetNamespace())
+	if u.GetResourceVersion() == "" {
+		current, err := client.Get(ctx, u.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to update %s", key)
+		}
+		u.SetResourceVersion(current.GetResourceVersion())
+	}
+	written, err := client.Update(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update %s", key)
+	}
+	s.written(res, key, obj, written)
+	if reconciliationLoop {
+		klog.Infof("Update key:[%s], resourceVersion:[%s]", key, written.GetResourceVersion())
+		s.inject(obj)
+	}
+	return nil
+}
+
+// Delete deletes obj from the API server. The API server only sets the deletionTimestamp of an object with
+// finalizers, which is injected into the workqueue of its controller by the watch.
+func (s *KubeStore) Delete(obj interface{}) error {
+	res, err := resourceForObject(obj)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return KeyError{obj, err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeRequestTimeout)
+	defer cancel()
+	err = s.resourceClient(res, accessor.GetNamespace()).Delete(ctx, accessor.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete %s/%s/%s", res.kind, accessor.GetNamespace(), accessor.GetName())
+	}
+	return nil
+}
+
+// List returns the objects of the informers
+func (s *KubeStore) List() []interface{} {
+	var list []interface{}
+	for i := range kubeResources {
+		res := &kubeResources[i]
+		items, err := s.factory.ForResource(res.gvr).Lister().List(labels.Everything())
+		if err != nil {
+			klog.ErrorS(err, "Failed to list the objects", "Kind", res.kind)
+			continue
+		}
+		for _, item := range items {
+			obj, err := res.fromUnstructured(item.(*unstructured.Unstructured))
+			if err != nil {
+				klog.ErrorS(err, "Failed to convert an object", "Kind", res.kind)
+				continue
+			}
+			list = append(list, obj)
+		}
+	}
+	return list
+}
+
+// Get returns the object of the key of obj
+func (s *KubeStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	key, err := MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, KeyError{obj, err}
+	}
+	return s.GetByKey(key)
+}
+
+// GetByKey returns the object of key, Kind/namespace/name, from the API server
+func (s *KubeStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return nil, false, fmt.Errorf("invalid key %q", key)
+	}
+	res, err := resourceForKind(parts[0])
+	if err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeRequestTimeout)
+	defer cancel()
+	u, err := s.resourceClient(res, parts[1]).Get(ctx, parts[2], metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, fmt.Errorf("cannot find obj from store... ")
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to get %s", key)
+	}
+	obj, err := res.fromUnstructured(u)
+	if err != nil {
+		return nil, true, err
+	}
+	return obj, true, nil
+}
+
+// Add Informer
+func (s *KubeStore) AddInformer(obj runtime.Object, informer cache.Controller) {
+	switch obj.(type) {
+	case *types.Provider:
+		s.InformerProvider = informer
+	case *types.Configuration:
+		s.InformerConfig = informer
+	}
+}
+
+// resourceClient returns the client of the objects of res in namespace
+func (s *KubeStore) resourceClient(res *kubeResource, namespace string) dynamic.ResourceInterface {
+	if !res.namespaced {
+		return s.client.Resource(res.gvr)
+	}
+	return s.client.Resource(res.gvr).Namespace(namespace)
+}
+
+// written copies the metadata set by the API server into obj, and remembers the resource version written of the
+// watched objects
+func (s *KubeStore) written(res *kubeResource, key string, obj interface{}, written *unstructured.Unstructured) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(written.GetResourceVersion())
+		accessor.SetUID(written.GetUID())
+		accessor.SetGeneration(written.GetGeneration())
+		accessor.SetCreationTimestamp(written.GetCreationTimestamp())
+	}
+	if res.watched() {
+		s.ownWrites[key] = written.GetResourceVersion()
+	}
+}
+
+// inject injects obj into the workqueue of its controller
+func (s *KubeStore) inject(obj interface{}) {
+	switch obj.(type) {
+	case *types.Provider:
+		if s.InformerProvider != nil {
+			s.InformerProvider.InjectWorkerQueue(obj)
+		}
+	case *types.Configuration:
+		if s.InformerConfig != nil {
+			s.InformerConfig.InjectWorkerQueue(obj)
+		}
+	}
+}
+
+// onWatchEvent injects the watched objects written by others into the workqueue of their controller
+func (s *KubeStore) onWatchEvent(res *kubeResource, item interface{}) {
+	u, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := res.kind + "/" + u.GetNamespace() + "/" + u.GetName()
+	s.watchedLock.Lock()
+	written, ok := s.ownWrites[key]
+	s.watchedLock.Unlock()
+	if ok && written != "" && written == u.GetResourceVersion() {
+		return
+	}
+	obj, err := res.fromUnstructured(u)
+	if err != nil {
+		klog.ErrorS(err, "Failed to convert a watched object", "Key", key)
+		return
+	}
+	klog.Infof("Watched key:[%s], resourceVersion:[%s]", key, u.GetResourceVersion())
+	s.inject(obj)
+}
+
+// onWatchDelete forgets the resource version written of the deleted watched objects
+func (s *KubeStore) onWatchDelete(res *kubeResource, item interface{}) {
+	if tombstone, ok := item.(k8scache.DeletedFinalStateUnknown); ok {
+		item = tombstone.Obj
+	}
+	u, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	s.watchedLock.Lock()
+	delete(s.ownWrites, res.kind+"/"+u.GetNamespace()+"/"+u.GetName())
+	s.watchedLock.Unlock()
+}
+
+// toUnstructured returns the resource of obj, and obj as written to the API server
+func (s *KubeStore) toUnstructured(obj interface{}) (*kubeResource, *unstructured.Unstructured, error) {
+	res, err := resourceForObject(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret, ok := obj.(*types.Secret); ok {
+		secret.MergeStringData()
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to encode the %s", res.kind)
+	}
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &u.Object); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to encode the %s", res.kind)
+	}
+	u.SetAPIVersion(res.gvr.GroupVersion().String())
+	u.SetKind(res.kind)
+	if !res.namespaced {
+		u.SetNamespace("")
+	}
+	return res, u, nil
+}
+
+// fromUnstructured returns the object of res read from the API server
+func (r *kubeResource) fromUnstructured(u *unstructured.Unstructured) (runtime.Object, error) {
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	obj := r.newObject()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the %s %s/%s", r.kind, u.GetNamespace(), u.GetName())
+	}
+	return obj, nil
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ttsubo2000/terraform-controller/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// fakeInformer records the objects injected into the workqueue of a controller
+type fakeInformer struct {
+	mu       sync.Mutex
+	injected []interface{}
+}
+
+func (f *fakeInformer) Run(stopCh <-chan struct{})      { <-stopCh }
+func (f *fakeInformer) HasSynced() bool                 { return true }
+func (f *fakeInformer) LastSyncResourceVersion() string { return "" }
+func (f *fakeInformer) InjectWorkerQueue(obj interface{}) {
+	f.mu.Lock()
+	f.injected = append(f.injected, obj)
+	f.mu.Unlock()
+}
+
+func (f *fakeInformer) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, obj := range f.injected {
+		names = append(names, obj.(*types.Configuration).Name)
+	}
+	return names
+}
+
+// newFakeKubeStore returns a started KubeStore on a fake dynamic client, and the informer of its Configurations
+func newFakeKubeStore(t *testing.T) (*KubeStore, *fake.FakeDynamicClient, *fakeInformer) {
+	t.Helper()
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, res := range kubeResources {
+		listKinds[res.gvr] = res.kind + "List"
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	store := NewKubeStoreForClient(client)
+	informer := &fakeInformer{}
+	store.AddInformer(&types.Configuration{}, informer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go store.Start(ctx)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return store.Check() == nil, nil
+	}); err != nil {
+		t.Fatalf("the informers of the store did not sync: %v", err)
+	}
+	return store, client, informer
+}
+
+func TestKubeStoreWritesTheObjects(t *testing.T) {
+	store, _, informer := newFakeKubeStore(t)
+
+	secret := &types.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "s1", Namespace: "default"},
+		StringData: map[string]string{"token": "t1"},
+	}
+	if err := store.Add(secret); err != nil {
+		t.Fatal(err)
+	}
+	obj, exists, err := store.GetByKey("Secret/default/s1")
+	if err != nil || !exists {
+		t.Fatalf("expected the Secret to be stored, got %v", err)
+	}
+	if string(obj.(*types.Secret).Data["token"]) != "t1" {
+		t.Errorf("expected the string data to be merged into the data, got %v", obj.(*types.Secret).Data)
+	}
+
+	// Adding an object which exists replaces it, as in the in-memory Cache
+	secret = &types.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "s1", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("t2")},
+	}
+	if err := store.Add(secret); err != nil {
+		t.Fatal(err)
+	}
+	obj, _, err = store.GetByKey("Secret/default/s1")
+	if err != nil || string(obj.(*types.Secret).Data["token"]) != "t2" {
+		t.Fatalf("expected the Secret to be replaced, got %v", err)
+	}
+
+	configuration := &types.Configuration{
+		TypeMeta:   metav1.TypeMeta{Kind: "Configuration"},
+		ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "default"},
+		Spec:       types.ConfigurationSpec{HCL: `output "name" { value = "c1" }`},
+	}
+	if err := store.Add(configuration); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Status.Apply.State = types.Available
+	if err := store.Update(configuration, false); err != nil {
+		t.Fatal(err)
+	}
+	obj, _, err = store.GetByKey("Configuration/default/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := obj.(*types.Configuration); c.Spec.HCL != configuration.Spec.HCL || c.Status.Apply.State != types.Available {
+		t.Errorf("expected the Configuration to be updated with its status, got %+v", c)
+	}
+	if names := informer.names(); len(names) == 0 || names[0] != "c1" {
+		t.Errorf("expected the added Configuration to be injected into the workqueue, got %v", names)
+	}
+
+	if err := store.Delete(secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, _ := store.GetByKey("Secret/default/s1"); exists {
+		t.Error("expected the Secret to be deleted")
+	}
+	if err := store.Delete(secret); err != nil {
+		t.Errorf("expected the deletion of a deleted object to succeed, got %v", err)
+	}
+}
+
+func TestKubeStoreListsTheObjectsOfTheInformers(t *testing.T) {
+	store, _, _ := newFakeKubeStore(t)
+
+	for _, name := range []string{"cm1", "cm2"} {
+		if err := store.Add(&types.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		count := 0
+		for _, obj := range store.List() {
+			if _, ok := obj.(*types.ConfigMap); ok {
+				count++
+			}
+		}
+		return count == 2, nil
+	}); err != nil {
+		t.Fatalf("expected the ConfigMaps to be listed: %v", err)
+	}
+}
+
+func TestKubeStoreInjectsTheConfigurationsWrittenByOthers(t *testing.T) {
+	store, client, informer := newFakeKubeStore(t)
+
+	// A Configuration created with kubectl
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("terraform.core.oam.dev/v1beta2")
+	u.SetKind("Configuration")
+	u.SetNamespace("default")
+	u.SetName("c2")
+	res, err := resourceForKind("Configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(res.gvr).Namespace("default").Create(context.Background(), u, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		for _, name := range informer.names() {
+			if name == "c2" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
+		t.Fatalf("expected the Configuration written by others to be injected into the workqueue: %v", err)
+	}
+	if _, exists, err := store.GetByKey("Configuration/default/c2"); err != nil || !exists {
+		t.Errorf("expected the Configuration written by others to be in the store, got %v", err)
+	}
+}
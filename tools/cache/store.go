@@ -2,7 +2,12 @@ package cache
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
+	"github.com/pkg/errors"
 	"github.com/ttsubo/client-go/tools/cache"
 	"github.com/ttsubo2000/terraform-controller/types"
 	v1 "k8s.io/api/core/v1"
@@ -16,6 +21,9 @@ import (
 
 const configurationFinalizer = "configuration.finalizers.terraform-controller"
 
+// ErrSecretEncryptionNotConfigured is returned when re-encrypting the Secrets of a Store which does not encrypt them
+var ErrSecretEncryptionNotConfigured = errors.New("the encryption of Secrets is not configured")
+
 // Store is a generic object storage and processing interface.
 type Store interface {
 
@@ -40,6 +48,12 @@ type Store interface {
 	AddInformer(obj runtime.Object, informer cache.Controller)
 }
 
+// Checker is implemented by the Stores whose backend may be unavailable, unlike the in-memory Cache
+type Checker interface {
+	// Check returns an error when the backend of the Store is unavailable
+	Check() error
+}
+
 // KeyFunc knows how to make a key from an object. Implementations should be deterministic.
 type KeyFunc func(obj interface{}) (string, error)
 
@@ -70,6 +84,12 @@ func MetaNamespaceKeyFunc(obj interface{}) (string, error) {
 	if len(meta.GetNamespace()) > 0 && len(meta.GetGenerateName()) > 0 {
 		return meta.GetGenerateName() + "/" + meta.GetNamespace() + "/" + meta.GetName(), nil
 	}
+	// Objects which are not customized for this program, like ClusterRoles, are keyed by their kind as well
+	if o, ok := obj.(runtime.Object); ok {
+		if kind := o.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+			return kind + "/" + meta.GetNamespace() + "/" + meta.GetName(), nil
+		}
+	}
 	return meta.GetName(), nil
 }
 
@@ -82,6 +102,14 @@ type Cache struct {
 	// should be deterministic.
 	keyFunc KeyFunc
 
+	// resourceVersion is the last resource version handed out to a stored object
+	resourceVersion uint64
+
+	// encryption encrypts the data of the stored Secrets when it is set
+	encryption *SecretEncryption
+	// secretsLock serializes the writes of Secrets with their encryption again
+	secretsLock sync.Mutex
+
 	// setup informer
 	InformerConfig   cache.Controller
 	InformerProvider cache.Controller
@@ -89,13 +117,60 @@ type Cache struct {
 
 //var _ Store = &cache{}
 
+// setResourceVersion stamps obj with the next resource version, so that writers can detect concurrent modifications
+func (c *Cache) setResourceVersion(obj interface{}) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(strconv.FormatUint(atomic.AddUint64(&c.resourceVersion, 1), 10))
+	}
+}
+
+// sealSecret returns what is stored for obj, which is a copy with encrypted data for the Secrets when the encryption
+// is set. The stringData of the Secrets is merged into their data first.
+func (c *Cache) sealSecret(obj interface{}) (interface{}, error) {
+	secret, ok := obj.(*types.Secret)
+	if !ok {
+		return obj, nil
+	}
+	secret.MergeStringData()
+	if c.encryption == nil {
+		return obj, nil
+	}
+	sealed, err := c.encryption.seal(secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encrypt the Secret %s/%s", secret.Namespace, secret.Name)
+	}
+	return sealed, nil
+}
+
+// openSecret returns the stored item as it was written, decrypting the data of the Secrets
+func (c *Cache) openSecret(item interface{}) (interface{}, error) {
+	secret, ok := item.(*types.Secret)
+	if !ok || c.encryption == nil {
+		return item, nil
+	}
+	opened, err := c.encryption.open(secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt the Secret %s/%s", secret.Namespace, secret.Name)
+	}
+	return opened, nil
+}
+
 // Add inserts an item into the cache.
 func (c *Cache) Add(obj interface{}) error {
 	key, err := c.keyFunc(obj)
 	if err != nil {
 		return KeyError{obj, err}
 	}
-	c.cacheStorage.Add(key, obj)
+	c.secretsLock.Lock()
+	c.setResourceVersion(obj)
+	stored, err := c.sealSecret(obj)
+	if err == nil {
+		c.cacheStorage.Add(key, stored)
+	}
+	c.secretsLock.Unlock()
+	if err != nil {
+		return err
+	}
 
 	switch obj.(type) {
 	case *types.Provider:
@@ -105,7 +180,13 @@ func (c *Cache) Add(obj interface{}) error {
 		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*types.Configuration))
 		c.InformerConfig.InjectWorkerQueue(obj)
 	case *types.Secret:
-		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*types.Secret))
+		// Only the keys of the Secrets are logged, their values are secret and may be binary
+		dataKeys := make([]string, 0, len(stored.(*types.Secret).Data))
+		for k := range stored.(*types.Secret).Data {
+			dataKeys = append(dataKeys, k)
+		}
+		sort.Strings(dataKeys)
+		klog.Infof("Update key:[%s], data keys:%v", key, dataKeys)
 	case *types.ConfigMap:
 		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*types.ConfigMap))
 	case *rbacv1.ClusterRole:
@@ -114,6 +195,10 @@ func (c *Cache) Add(obj interface{}) error {
 		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*v1.ServiceAccount))
 	case *rbacv1.ClusterRoleBinding:
 		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*rbacv1.ClusterRoleBinding))
+	case *rbacv1.Role:
+		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*rbacv1.Role))
+	case *rbacv1.RoleBinding:
+		klog.Infof("Update key:[%s], obj:[%v]", key, obj.(*rbacv1.RoleBinding))
 	}
 	return nil
 }
@@ -124,7 +209,16 @@ func (c *Cache) Update(obj interface{}, reconciliationLoop bool) error {
 	if err != nil {
 		return KeyError{obj, err}
 	}
-	c.cacheStorage.Update(key, obj)
+	c.secretsLock.Lock()
+	c.setResourceVersion(obj)
+	stored, err := c.sealSecret(obj)
+	if err == nil {
+		c.cacheStorage.Update(key, stored)
+	}
+	c.secretsLock.Unlock()
+	if err != nil {
+		return err
+	}
 	if reconciliationLoop {
 		switch obj.(type) {
 		case *types.Provider:
@@ -163,7 +257,20 @@ func (c *Cache) Delete(obj interface{}) error {
 // List returns a list of all the items.
 // List is completely threadsafe as long as you treat all items as immutable.
 func (c *Cache) List() []interface{} {
-	return c.cacheStorage.List()
+	items := c.cacheStorage.List()
+	if c.encryption == nil {
+		return items
+	}
+	list := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		opened, err := c.openSecret(item)
+		if err != nil {
+			klog.ErrorS(err, "Failed to list a Secret")
+			continue
+		}
+		list = append(list, opened)
+	}
+	return list
 }
 
 // Get returns the requested item, or sets exists=false.
@@ -182,9 +289,48 @@ func (c *Cache) GetByKey(key string) (item interface{}, exists bool, err error)
 	item, exists = c.cacheStorage.Get(key)
 	if exists == false {
 		return item, exists, fmt.Errorf("cannot find obj from store... ")
-	} else {
-		return item, exists, nil
 	}
+	item, err = c.openSecret(item)
+	if err != nil {
+		return nil, exists, err
+	}
+	return item, exists, nil
+}
+
+// ReencryptSecrets reloads the key file of the encryption, and encrypts every Secret again with its primary key
+func (c *Cache) ReencryptSecrets() (int, error) {
+	if c.encryption == nil {
+		return 0, ErrSecretEncryptionNotConfigured
+	}
+	reseal, commit, err := c.encryption.rotate()
+	if err != nil {
+		return 0, err
+	}
+
+	c.secretsLock.Lock()
+	defer c.secretsLock.Unlock()
+	resealed := map[string]interface{}{}
+	for _, item := range c.cacheStorage.List() {
+		sealed, ok := item.(*types.Secret)
+		if !ok {
+			continue
+		}
+		key, err := c.keyFunc(sealed)
+		if err != nil {
+			return 0, KeyError{sealed, err}
+		}
+		// Nothing is written until every Secret is encrypted again, so that a key missing from the key file
+		// does not leave the Secrets encrypted with several key files
+		if resealed[key], err = reseal(sealed); err != nil {
+			return 0, err
+		}
+	}
+	for key, secret := range resealed {
+		c.cacheStorage.Update(key, secret)
+	}
+	commit()
+	klog.InfoS("Encrypted the Secrets again", "Count", len(resealed), "Encryption", c.encryption.String())
+	return len(resealed), nil
 }
 
 // Add Informer
@@ -204,3 +350,13 @@ func NewStore(keyFunc KeyFunc) Store {
 		keyFunc:      keyFunc,
 	}
 }
+
+// NewEncryptedStore returns a Store like NewStore, which keeps the data of the Secrets encrypted with encryption.
+// The Secrets are decrypted when they are read.
+func NewEncryptedStore(keyFunc KeyFunc, encryption *SecretEncryption) Store {
+	return &Cache{
+		cacheStorage: NewThreadSafeStore(),
+		keyFunc:      keyFunc,
+		encryption:   encryption,
+	}
+}
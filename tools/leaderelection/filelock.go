@@ -0,0 +1,182 @@
+package leaderelection
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// leaseResource is the resource reported by the errors of the FileLock, as for a Lease
+var leaseResource = schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}
+
+// fileRecord is the content of the lock file. Its version is the fencing token of the record: a write is rejected
+// when the file changed since it was read, like a stale leader renewing a lease taken over by another instance.
+type fileRecord struct {
+	Version uint64                            `json:"version"`
+	Record  resourcelock.LeaderElectionRecord `json:"record"`
+}
+
+// FileLock is a resourcelock.Interface on a file shared by the instances of the controller, like on a volume.
+// The reads and writes of the file are serialized with an flock on the file suffixed with .lock.
+type FileLock struct {
+	path     string
+	identity string
+
+	mu sync.Mutex
+	// version is the version of the record last read or written
+	version uint64
+}
+
+var _ resourcelock.Interface = &FileLock{}
+
+// NewFileLock returns the FileLock of the record in path, held by identity
+func NewFileLock(path, identity string) *FileLock {
+	return &FileLock{path: path, identity: identity}
+}
+
+// Get returns the record of the lock file, or a NotFound error when there is none yet
+func (l *FileLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	var record *fileRecord
+	err := l.withFlock(func() error {
+		var err error
+		record, err = l.read()
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		return nil, nil, apierrors.NewNotFound(leaseResource, l.path)
+	}
+	raw, err := json.Marshal(record.Record)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.mu.Lock()
+	l.version = record.Version
+	l.mu.Unlock()
+	return &record.Record, raw, nil
+}
+
+// Create writes the first record of the lock file
+func (l *FileLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.withFlock(func() error {
+		current, err := l.read()
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return apierrors.NewAlreadyExists(leaseResource, l.path)
+		}
+		return l.write(&fileRecord{Version: 1, Record: ler})
+	})
+}
+
+// Update writes the record of the lock file, unless it changed since it was last read or written
+func (l *FileLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.mu.Lock()
+	version := l.version
+	l.mu.Unlock()
+	if version == 0 {
+		return errors.New("the lock file must be read before it is updated")
+	}
+	return l.withFlock(func() error {
+		current, err := l.read()
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return apierrors.NewNotFound(leaseResource, l.path)
+		}
+		if current.Version != version {
+			return apierrors.NewConflict(leaseResource, l.path,
+				errors.Errorf("the lock file is at version %d, not %d", current.Version, version))
+		}
+		return l.write(&fileRecord{Version: version + 1, Record: ler})
+	})
+}
+
+// RecordEvent logs the leader election events, as there is no object for their Events
+func (l *FileLock) RecordEvent(s string) {
+	klog.InfoS("Leader election", "Lock", l.path, "Event", l.identity+" "+s)
+}
+
+// Identity returns the identity of the instance holding the lock
+func (l *FileLock) Identity() string {
+	return l.identity
+}
+
+// Describe returns the path of the lock file
+func (l *FileLock) Describe() string {
+	return l.path
+}
+
+// read returns the record of the lock file, nil when there is none
+func (l *FileLock) read() (*fileRecord, error) {
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the lock file")
+	}
+	record := &fileRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the lock file")
+	}
+	return record, nil
+}
+
+// write replaces the record of the lock file atomically, and remembers its version
+func (l *FileLock) write(record *fileRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), "."+filepath.Base(l.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write the lock file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write the lock file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write the lock file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write the lock file")
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return errors.Wrap(err, "failed to write the lock file")
+	}
+	l.mu.Lock()
+	l.version = record.Version
+	l.mu.Unlock()
+	return nil
+}
+
+// withFlock runs f with the exclusive flock of the lock file
+func (l *FileLock) withFlock(f func() error) error {
+	lockFile, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the lock file")
+	}
+	defer lockFile.Close()
+	if err := flock(lockFile); err != nil {
+		return errors.Wrap(err, "failed to lock the lock file")
+	}
+	defer funlock(lockFile)
+	return f()
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package leaderelection
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// flock is not supported on Windows
+func flock(f *os.File) error {
+	return errors.New("the lock file is not supported on Windows")
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
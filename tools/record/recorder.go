@@ -0,0 +1,213 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+
+	cacheObj "github.com/ttsubo2000/terraform-controller/tools/cache"
+	"github.com/ttsubo2000/terraform-controller/types"
+)
+
+const (
+	// DefaultTTL is how long an Event is kept after its last occurrence
+	DefaultTTL = time.Hour
+
+	// maxMessageLength is the maximum length of the message of an Event, as in Kubernetes
+	maxMessageLength = 1024
+	// spamBurst and spamInterval rate limit the new Events of each object, as the spam filter of client-go.
+	// An Event which occurred already is always counted.
+	spamBurst    = 25
+	spamInterval = 5 * time.Minute
+	// expireInterval is how often the expired Events are deleted
+	expireInterval = time.Minute
+)
+
+// EventRecorder records Events about the objects of the store
+type EventRecorder interface {
+	// Event records an event of eventtype, Normal or Warning, about object. The reason is a short CamelCase
+	// string, the message is for humans.
+	Event(object runtime.Object, eventtype, reason, message string)
+
+	// Eventf is just like Event, but with Sprintf for the message.
+	Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{})
+}
+
+// Recorder stores the Events in the store. The Events of the same object, type, reason and message are
+// aggregated by their count, and are deleted once they did not occur for their TTL.
+type Recorder struct {
+	client    cacheObj.Store
+	component string
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	limiters map[string]*objectLimiter
+}
+
+// objectLimiter limits the new Events of an object
+type objectLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+var _ EventRecorder = &Recorder{}
+
+// NewRecorder returns a Recorder storing the Events reported by component in client. The Events are kept for ttl
+// after their last occurrence, DefaultTTL when it is zero.
+func NewRecorder(client cacheObj.Store, component string, ttl time.Duration) *Recorder {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Recorder{
+		client:    client,
+		component: component,
+		ttl:       ttl,
+		limiters:  map[string]*objectLimiter{},
+	}
+}
+
+// Event records an Event about object
+func (r *Recorder) Event(object runtime.Object, eventtype, reason, message string) {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		klog.ErrorS(err, "Could not record the event of an object without metadata", "Reason", reason)
+		return
+	}
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength-3] + "..."
+	}
+	involved := v1.ObjectReference{
+		Kind:            object.GetObjectKind().GroupVersionKind().Kind,
+		APIVersion:      object.GetObjectKind().GroupVersionKind().GroupVersion().String(),
+		Namespace:       accessor.GetNamespace(),
+		Name:            accessor.GetName(),
+		UID:             accessor.GetUID(),
+		ResourceVersion: accessor.GetResourceVersion(),
+	}
+	objectKey := involved.Kind + "/" + involved.Namespace + "/" + involved.Name
+	klog.InfoS("Event occurred", "Object", objectKey, "Type", eventtype, "Reason", reason, "Message", message)
+
+	namespace := involved.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	name := fmt.Sprintf("%s.%x", involved.Name, eventHash(objectKey, eventtype, reason, message))
+	now := metav1.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj, exists, err := r.client.GetByKey("Event/" + namespace + "/" + name)
+	if err == nil && exists {
+		event := obj.(*types.Event)
+		event.Count++
+		event.LastTimestamp = now
+		event.InvolvedObject.ResourceVersion = involved.ResourceVersion
+		if err := r.client.Update(event, false); err != nil {
+			klog.ErrorS(err, "Failed to update the event", "Event", name)
+		}
+		return
+	}
+
+	if !r.allow(objectKey, now.Time) {
+		klog.V(2).InfoS("Dropped the event of an object recording too many events", "Object", objectKey, "Reason", reason)
+		return
+	}
+	event := &types.Event{
+		TypeMeta: metav1.TypeMeta{Kind: "Event", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: r.component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventtype,
+	}
+	if err := r.client.Add(event); err != nil {
+		klog.ErrorS(err, "Failed to record the event", "Event", name)
+	}
+}
+
+// Eventf records an Event about object, with Sprintf for the message
+func (r *Recorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// allow takes a token of the rate limiter of the object
+func (r *Recorder) allow(objectKey string, now time.Time) bool {
+	l, ok := r.limiters[objectKey]
+	if !ok {
+		l = &objectLimiter{limiter: rate.NewLimiter(rate.Every(spamInterval), spamBurst)}
+		r.limiters[objectKey] = l
+	}
+	l.lastSeen = now
+	return l.limiter.AllowN(now, 1)
+}
+
+// NeedLeaderElection returns false, as the standby instances record Events as well
+func (r *Recorder) NeedLeaderElection() bool {
+	return false
+}
+
+// Start deletes the expired Events until ctx is done.
+func (r *Recorder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.expire(time.Now())
+		}
+	}
+}
+
+// expire deletes the Events which did not occur for their TTL, and forgets the rate limiters which are full again
+func (r *Recorder) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expired := 0
+	for _, obj := range r.client.List() {
+		event, ok := obj.(*types.Event)
+		if !ok || now.Sub(event.LastTimestamp.Time) < r.ttl {
+			continue
+		}
+		if err := r.client.Delete(event); err != nil {
+			klog.ErrorS(err, "Failed to delete the expired event", "Event", event.Name)
+			continue
+		}
+		expired++
+	}
+	if expired > 0 {
+		klog.V(2).InfoS("Deleted the expired events", "Count", expired)
+	}
+	for key, l := range r.limiters {
+		if now.Sub(l.lastSeen) > spamBurst*spamInterval {
+			delete(r.limiters, key)
+		}
+	}
+}
+
+// eventHash identifies the Events which are aggregated
+func eventHash(parts ...string) uint64 {
+	h := fnv.New64a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -27,6 +27,9 @@ type ConfigurationSpec struct {
 	// Path is the sub-directory of remote git repository.
 	Path string `json:"path,omitempty"`
 
+	// Timeouts bounds how long the terraform commands run, the defaults of the controller bound those not set
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+
 	BaseConfigurationSpec `json:",inline"`
 }
 
@@ -41,6 +44,10 @@ type BaseConfigurationSpec struct {
 	// ProviderReference specifies the reference to Provider
 	ProviderReference *types.Reference `json:"providerRef,omitempty"`
 
+	// ProviderReferences specifies several Providers, like the AWS accounts of peered VPCs. It can not be set
+	// together with ProviderReference.
+	ProviderReferences []ProviderReference `json:"providerRefs,omitempty"`
+
 	// DeleteResource will determine whether provisioned cloud resources will be deleted when CR is deleted
 	DeleteResource bool `json:"deleteResource,omitempty"`
 
@@ -48,6 +55,27 @@ type BaseConfigurationSpec struct {
 	Region string `json:"customRegion,omitempty"`
 }
 
+// Timeouts are the longest durations of the terraform commands of a Configuration, a zero one is unlimited
+type Timeouts struct {
+	Init    *metav1.Duration `json:"init,omitempty"`
+	Plan    *metav1.Duration `json:"plan,omitempty"`
+	Apply   *metav1.Duration `json:"apply,omitempty"`
+	Destroy *metav1.Duration `json:"destroy,omitempty"`
+}
+
+// ProviderReference references one of the Providers of a Configuration
+type ProviderReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Alias is the alias of the provider block configured with the credentials of the Provider. The Provider without
+	// alias configures the default provider block, at most one Provider can have no alias.
+	Alias string `json:"alias,omitempty"`
+
+	// Region overrides the region of the Provider
+	Region string `json:"region,omitempty"`
+}
+
 // ConfigurationStatus defines the observed state of Configuration
 type ConfigurationStatus struct {
 	// observedGeneration is the most recent generation observed for this Configuration. It corresponds to the
@@ -20,6 +20,20 @@ type ProviderSpec struct {
 	Credentials ProviderCredentials `json:"credentials"`
 }
 
+const (
+	// CredentialsSourceFilesystem indicates that a provider should acquire
+	// credentials from a file of the controller's filesystem.
+	CredentialsSourceFilesystem crossplanetypes.CredentialsSource = "Filesystem"
+
+	// CredentialsSourceEnvironment indicates that a provider should acquire
+	// credentials from environment variables of the controller.
+	CredentialsSourceEnvironment crossplanetypes.CredentialsSource = "Environment"
+
+	// CredentialsSourceVault indicates that a provider should acquire
+	// credentials from the KV v2 secrets engine of a Vault-compatible server.
+	CredentialsSourceVault crossplanetypes.CredentialsSource = "Vault"
+)
+
 // ProviderCredentials required to authenticate.
 type ProviderCredentials struct {
 	// Source of the provider credentials.
@@ -28,12 +42,141 @@ type ProviderCredentials struct {
 	// A SecretRef is a reference to a secret key that contains the credentials
 	// that must be used to connect to the provider.
 	SecretRef crossplanetypes.SecretKeySelector `json:"secretRef,omitempty"`
+
+	// Fs is a reference to a filesystem location that contains credentials that
+	// must be used to connect to the provider.
+	Fs *FsSelector `json:"fs,omitempty"`
+
+	// Env is a reference to environment variables that contain credentials that
+	// must be used to connect to the provider.
+	Env *EnvSelector `json:"env,omitempty"`
+
+	// Vault is a reference to a secret of a Vault KV v2 secrets engine that contains
+	// credentials that must be used to connect to the provider.
+	Vault *VaultSelector `json:"vault,omitempty"`
+}
+
+// FsSelector selects a filesystem location.
+type FsSelector struct {
+	// Path is a filesystem path. The file holds the credentials in the same format as the data of a Secret,
+	// it is read again when it changes.
+	Path string `json:"path"`
+}
+
+// EnvSelector selects environment variables of the controller.
+type EnvSelector struct {
+	// Name is the name of an environment variable holding the credentials in the same format as the data of
+	// a Secret.
+	Name string `json:"name,omitempty"`
+
+	// Mapping builds the credentials from several environment variables, it maps the fields of the credentials,
+	// like awsAccessKeyID, to the names of the environment variables holding their values.
+	Mapping map[string]string `json:"mapping,omitempty"`
+}
+
+// VaultSelector selects a secret of a Vault KV v2 secrets engine.
+type VaultSelector struct {
+	// Address is the URL of the Vault server, by default the environment variable VAULT_ADDR of the controller.
+	Address string `json:"address,omitempty"`
+
+	// Mount is the mount path of the KV v2 secrets engine, secret by default.
+	Mount string `json:"mount,omitempty"`
+
+	// Path is the path of the secret in the secrets engine.
+	Path string `json:"path"`
+
+	// Key is the key of the secret holding the credentials in the same format as the data of a Secret. Without
+	// key, the keys of the secret are the fields of the credentials, like awsAccessKeyID.
+	Key string `json:"key,omitempty"`
+
+	// Auth is how the controller authenticates to Vault, by default with the environment variable VAULT_TOKEN of
+	// the controller.
+	Auth VaultAuth `json:"auth,omitempty"`
+}
+
+// VaultAuth is how the controller authenticates to Vault, at most one is set.
+type VaultAuth struct {
+	// TokenSecretRef references the key of a Secret holding a Vault token.
+	TokenSecretRef *crossplanetypes.SecretKeySelector `json:"tokenSecretRef,omitempty"`
+
+	// AppRole logs in with the AppRole auth method.
+	AppRole *VaultAppRole `json:"appRole,omitempty"`
+}
+
+// VaultAppRole logs in to Vault with the AppRole auth method.
+type VaultAppRole struct {
+	// Mount is the mount path of the AppRole auth method, approle by default.
+	Mount string `json:"mount,omitempty"`
+
+	RoleID string `json:"roleID"`
+
+	// SecretIDRef references the key of a Secret holding the secret ID of the role.
+	SecretIDRef crossplanetypes.SecretKeySelector `json:"secretIDRef"`
 }
 
 // ProviderStatus defines the observed state of Provider.
 type ProviderStatus struct {
 	State   ProviderState `json:"state,omitempty"`
 	Message string        `json:"message,omitempty"`
+
+	// CredentialsExpiry is when the temporary credentials of the Provider expire, like those of an assumed role
+	CredentialsExpiry *metav1.Time `json:"credentialsExpiry,omitempty"`
+
+	// Conditions are the latest observations of the credentials of the Provider
+	Conditions []ProviderCondition `json:"conditions,omitempty"`
+}
+
+// ProviderConditionType is the type of a condition of a Provider
+type ProviderConditionType string
+
+const (
+	// ProviderSecretFound is true when the credentials data is read from its source, like a Secret
+	ProviderSecretFound ProviderConditionType = "SecretFound"
+	// ProviderCredentialsValid is true when the credentials are validated, and checked against the cloud provider
+	// when it supports live checks
+	ProviderCredentialsValid ProviderConditionType = "CredentialsValid"
+)
+
+// ProviderCondition is an observation of the credentials of a Provider
+type ProviderCondition struct {
+	Type   ProviderConditionType  `json:"type"`
+	Status metav1.ConditionStatus `json:"status"`
+
+	// LastTransitionTime is when the condition last changed its status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// LastValidatedTime is when the condition was last evaluated
+	LastValidatedTime metav1.Time `json:"lastValidatedTime,omitempty"`
+
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// GetCondition returns the condition of type t, nil when the Provider has none
+func (s *ProviderStatus) GetCondition(t ProviderConditionType) *ProviderCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the same type. The transition time is kept while the status of the
+// condition does not change.
+func (s *ProviderStatus) SetCondition(c ProviderCondition) {
+	existing := s.GetCondition(c.Type)
+	if existing == nil {
+		c.LastTransitionTime = c.LastValidatedTime
+		s.Conditions = append(s.Conditions, c)
+		return
+	}
+	if existing.Status == c.Status {
+		c.LastTransitionTime = existing.LastTransitionTime
+	} else {
+		c.LastTransitionTime = c.LastValidatedTime
+	}
+	*existing = c
 }
 
 // Provider is the Schema for the providers API.
@@ -27,7 +27,7 @@ type Secret struct {
 	// base64 encoded string, representing the arbitrary (possibly non-string)
 	// data value here. Described in https://tools.ietf.org/html/rfc4648#section-4
 	// +optional
-	Data map[string]string `json:"data,omitempty" protobuf:"bytes,4,rep,name=data"`
+	Data map[string][]byte `json:"data,omitempty" protobuf:"bytes,4,rep,name=data"`
 
 	// stringData allows specifying non-binary secret data in string form.
 	// It is provided as a write-only input field for convenience.
@@ -43,6 +43,22 @@ type Secret struct {
 	Type SecretType `json:"type,omitempty" protobuf:"bytes,3,opt,name=type,casttype=SecretType"`
 }
 
+// MergeStringData merges the keys and values of StringData into Data, overwriting the existing values, and clears
+// StringData, as the API server does on write
+func (s *Secret) MergeStringData() {
+	if len(s.StringData) == 0 {
+		s.StringData = nil
+		return
+	}
+	if s.Data == nil {
+		s.Data = make(map[string][]byte, len(s.StringData))
+	}
+	for k, v := range s.StringData {
+		s.Data[k] = []byte(v)
+	}
+	s.StringData = nil
+}
+
 func (s *Secret) DeepCopyObject() runTime.Object {
 	panic("not supported")
 }
@@ -57,3 +73,6 @@ func (s *Secret) GetGenerateName() string {
 }
 
 type SecretType string
+
+// MaxSecretSize is the maximum total size of the values of a Secret
+const MaxSecretSize = 1 * 1024 * 1024
@@ -29,6 +29,9 @@ const (
 	ConfigurationProvisioningAndChecking ConfigurationState = "ProvisioningAndChecking"
 	ConfigurationDestroying              ConfigurationState = "Destroying"
 	ConfigurationApplyFailed             ConfigurationState = "ApplyFailed"
+	ConfigurationResourceLimitExceeded   ConfigurationState = "ResourceLimitExceeded"
+	ConfigurationTimeout                 ConfigurationState = "Timeout"
+	ConfigurationCanceled                ConfigurationState = "Canceled"
 	ConfigurationDestroyFailed           ConfigurationState = "DestroyFailed"
 	ConfigurationReloading               ConfigurationState = "ConfigurationReloading"
 	GeneratingOutputs                    ConfigurationState = "GeneratingTerraformOutputs"